@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
 	"net/http"
+	"time"
 )
 
 // Artifact represents a basic artifact record
@@ -19,15 +19,46 @@ type Artifact struct {
 	ArtifactType     string                 `json:"artifactType,omitempty" bson:"artifactType,omitempty"`
 	ArtifactFamily   string                 `json:"artifactFamily,omitempty" bson:"artifactFamily,omitempty"`
 	ArtifactMetadata map[string]interface{} `json:"artifactMetadata,omitempty" bson:"artifactMetadata,omitempty"`
+	Dependencies     []string               `json:"dependencies,omitempty" bson:"dependencies,omitempty"` // IDs of the artifacts this artifact depends on
+	Promotions       []Promotion            `json:"promotions,omitempty" bson:"promotions,omitempty"`     // managed through /promotions, ignored on create & update
+	CreatedAt        *time.Time             `json:"createdAt,omitempty" bson:"createdAt,omitempty"`       // set by the server, ignored on create & update
+	UpdatedAt        *time.Time             `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`       // set by the server, ignored on create & update
+}
+
+// Promotion records an artifact being promoted into an environment
+type Promotion struct {
+	Environment string    `json:"environment" bson:"environment"` // dev
+	Revision    int       `json:"revision" bson:"revision"`       // artifact revision that was promoted
+	Timestamp   time.Time `json:"timestamp" bson:"timestamp"`
+	Actor       string    `json:"actor" bson:"actor"`
+}
+
+// Get the most recent promotion of the artifact into an environment, nil if it was never promoted there
+func (artifact Artifact) LatestPromotion(environment string) *Promotion {
+	var latest *Promotion
+	for i, promotion := range artifact.Promotions {
+		if promotion.Environment == environment {
+			latest = &artifact.Promotions[i]
+		}
+	}
+	return latest
+}
+
+// Replace the fields an update can change, promotions & createdAt are kept
+func (artifact *Artifact) applyUpdate(update Artifact) {
+	artifact.Name = update.Name
+	artifact.Description = update.Description
+	artifact.ArtifactType = update.ArtifactType
+	artifact.ArtifactFamily = update.ArtifactFamily
+	artifact.ArtifactMetadata = update.ArtifactMetadata
+	artifact.Dependencies = update.Dependencies
+	artifact.UpdatedAt = update.UpdatedAt
 }
 
 // Database & Collection for Artifacts
 const ArtifactDbName = "artifactdb"
 const ArtifactColName = "artifacts"
 
-// MongoDB client
-var client, _ = database.SetupMongoDbClient()
-
 // Create an artifact record
 func CreateArtifact(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -44,15 +75,28 @@ func CreateArtifact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
-	result, err := collection.InsertOne(r.Context(), artifact)
+	if !checkDependencies(w, artifact) {
+		return
+	}
+
+	// Promotions can only be recorded by the promotion workflow
+	artifact.Promotions = nil
+
+	now := time.Now().UTC()
+	artifact.CreatedAt = &now
+	artifact.UpdatedAt = &now
+
+	artifact.ID, err = GetRepository().Create(r.Context(), artifact)
 	if err != nil {
 		http.Error(w, "Unable to insert the record into the database", 417)
 		log.Println(err)
 		return
 	}
 
-	artifact.ID = result.InsertedID.(primitive.ObjectID)
+	if !recordRevision(w, r, artifact) {
+		return
+	}
+
 	json.NewEncoder(w).Encode(artifact)
 }
 
@@ -61,24 +105,14 @@ func GetArtifacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	fmt.Println("Info: Getting all Artifacts")
-	var artifacts []Artifact
-
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
-	cursor, err := collection.Find(r.Context(), bson.M{})
 
+	artifacts, err := GetRepository().List(r.Context())
 	if err != nil {
 		http.Error(w, "Unable to check Artifact collection with unset ID", 500)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var artifact Artifact
-		cursor.Decode(&artifact)
-		artifacts = append(artifacts, artifact)
-	}
-
 	json.NewEncoder(w).Encode(artifacts)
 }
 
@@ -87,11 +121,7 @@ func SearchArtifacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse request body
-	var filter struct {
-		SearchKey   string `json:"searchKey"`
-		SearchValue string `json:"searchValue"`
-		SearchVerb  string `json:"searchVerb"`
-	}
+	var filter database.SearchFilter
 
 	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
 		http.Error(w, "Invalid request body", 422)
@@ -103,51 +133,14 @@ func SearchArtifacts(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Searching Artifacts by " + filter.SearchKey + " where the attribute is set to " + filter.SearchValue + " with verb set to: " + filter.SearchVerb)
 
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
-
-	// Build the filter
-	query := bson.M{}
-	if filter.SearchKey != "" && filter.SearchValue != "" {
-		if filter.SearchVerb == "contains" {
-			query["$or"] = []bson.M{
-				{filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}},
-				{"artifactMetadata." + filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}},
-			}
-		} else {
-			query["$or"] = []bson.M{
-				{filter.SearchKey: filter.SearchValue},
-				{"artifactMetadata." + filter.SearchKey: filter.SearchValue},
-			}
-		}
-	}
-
-	// Print the query to the log
-	_, err := json.Marshal(query)
-	if err != nil {
-		log.Println("Error marshaling query to JSON:", err)
-		http.Error(w, "Unable to marshal database query response to JSON", 500)
-		return
-	}
-
-	// Retrieve artifacts matching the query
-	var artifacts []Artifact
-	cursor, err := collection.Find(r.Context(), query)
+	// Retrieve artifacts matching the filter
+	artifacts, err := GetRepository().Search(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var artifact Artifact
-		if err := cursor.Decode(&artifact); err != nil {
-			log.Println("Error decoding artifact:", err)
-			continue
-		}
-		artifacts = append(artifacts, artifact)
-	}
-
 	json.NewEncoder(w).Encode(artifacts)
 }
 
@@ -169,11 +162,7 @@ func GetArtifact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var artifact Artifact
-
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
-
-	err = collection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&artifact)
+	artifact, err := GetRepository().Get(r.Context(), id)
 	if err != nil {
 		// Handle the error / return a response
 		http.Error(w, "Unable to find artifact with that ID", http.StatusBadRequest)
@@ -195,27 +184,46 @@ func UpdateArtifact(w http.ResponseWriter, r *http.Request) {
 
 	var artifact Artifact
 	_ = json.NewDecoder(r.Body).Decode(&artifact)
+	artifact.ID = id
 
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
+	if !checkDependencies(w, artifact) {
+		return
+	}
 
-	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
-	update := bson.M{
-		"$set": bson.M{
-			"name":             artifact.Name,
-			"description":      artifact.Description,
-			"artifactType":     artifact.ArtifactType,
-			"artifactFamily":   artifact.ArtifactFamily,
-			"artifactMetadata": artifact.ArtifactMetadata,
-		},
+	stored, err := GetRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find artifact with that ID", http.StatusNotFound)
+		return
 	}
-	_, err := collection.UpdateOne(r.Context(), bson.M{"_id": id}, update)
 	if err != nil {
-		fmt.Println("Error receieved")
+		http.Error(w, "Failed to retrieve artifact", http.StatusInternalServerError)
 		log.Println(err)
+		return
 	}
 
-	artifact.ID = id
-	json.NewEncoder(w).Encode(artifact)
+	now := time.Now().UTC()
+	artifact.CreatedAt = nil
+	artifact.UpdatedAt = &now
+
+	err = GetRepository().Update(r.Context(), id, artifact)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find artifact with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to update the artifact record", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	// The revision is the artifact as this request wrote it, reading it back could pick up another writer's change
+	written := *stored
+	written.applyUpdate(artifact)
+	if !recordRevision(w, r, written) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(written)
 }
 
 // Delete an artifact record
@@ -227,8 +235,7 @@ func DeleteArtifact(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, _ := primitive.ObjectIDFromHex(params["id"])
 
-	collection := client.Database(ArtifactDbName).Collection(ArtifactColName)
-	_, err := collection.DeleteOne(r.Context(), bson.M{"_id": id})
+	err := GetRepository().Delete(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Unable to purge selected record out of the database", http.StatusBadRequest)
 		log.Println(err)
@@ -237,3 +244,19 @@ func DeleteArtifact(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("Artifact record deleted successfully.")
 
 }
+
+// Dependencies must be artifact IDs, writes the error response when one isn't
+func checkDependencies(w http.ResponseWriter, artifact Artifact) bool {
+	for _, dependency := range artifact.Dependencies {
+		id, err := primitive.ObjectIDFromHex(dependency)
+		if err != nil {
+			http.Error(w, "Invalid dependency, "+dependency+" is not an artifact ID", 422)
+			return false
+		}
+		if id == artifact.ID {
+			http.Error(w, "An artifact can't depend on itself", 422)
+			return false
+		}
+	}
+	return true
+}
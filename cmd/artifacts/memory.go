@@ -0,0 +1,149 @@
+package artifacts
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// MemoryRepository keeps Artifacts in process memory, used with DB_BACKEND=memory
+type MemoryRepository struct {
+	collection *database.MemoryCollection[Artifact]
+}
+
+// compile-time interface check
+var _ Repository = &MemoryRepository{}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		collection: database.NewMemoryCollection[Artifact](),
+	}
+}
+
+func (repo *MemoryRepository) Create(ctx context.Context, artifact Artifact) (primitive.ObjectID, error) {
+	if artifact.ID.IsZero() {
+		artifact.ID = primitive.NewObjectID()
+	}
+	return artifact.ID, repo.collection.Insert(artifact.ID, artifact)
+}
+
+func (repo *MemoryRepository) List(ctx context.Context) ([]Artifact, error) {
+	return repo.collection.Find(nil)
+}
+
+func (repo *MemoryRepository) Search(ctx context.Context, filter database.SearchFilter) ([]Artifact, error) {
+	var searchErr error
+	artifacts, err := repo.collection.Find(func(artifact Artifact) bool {
+		match, err := database.MatchesSearch(artifact, filter, filter.SearchKey, "artifactMetadata."+filter.SearchKey)
+		if err != nil {
+			searchErr = err
+		}
+		return match
+	})
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, searchErr
+}
+
+func (repo *MemoryRepository) Each(ctx context.Context, filter *database.SearchFilter, fn func(Artifact) error) error {
+	if filter == nil {
+		return repo.collection.Each(nil, fn)
+	}
+
+	var searchErr error
+	err := repo.collection.Each(func(artifact Artifact) bool {
+		match, err := database.MatchesSearch(artifact, *filter, filter.SearchKey, "artifactMetadata."+filter.SearchKey)
+		if err != nil {
+			searchErr = err
+		}
+		return match
+	}, fn)
+	if err != nil {
+		return err
+	}
+	return searchErr
+}
+
+func (repo *MemoryRepository) Get(ctx context.Context, id primitive.ObjectID) (*Artifact, error) {
+	return repo.collection.Get(id)
+}
+
+func (repo *MemoryRepository) Update(ctx context.Context, id primitive.ObjectID, artifact Artifact) error {
+	return repo.collection.Update(id, func(stored *Artifact) {
+		stored.applyUpdate(artifact)
+	})
+}
+
+func (repo *MemoryRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return repo.collection.Delete(id)
+}
+
+func (repo *MemoryRepository) AddPromotion(ctx context.Context, id primitive.ObjectID, promotion Promotion) error {
+	return repo.collection.Update(id, func(stored *Artifact) {
+		stored.Promotions = append(stored.Promotions, promotion)
+	})
+}
+
+func (repo *MemoryRepository) ListPromotedTo(ctx context.Context, environment string) ([]Artifact, error) {
+	return repo.collection.Find(func(artifact Artifact) bool {
+		for _, promotion := range artifact.Promotions {
+			if promotion.Environment == environment {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MemoryRevisionRepository keeps Artifact revisions in process memory, used with DB_BACKEND=memory
+type MemoryRevisionRepository struct {
+	mutex      sync.Mutex // serialises revision numbering
+	collection *database.MemoryCollection[ArtifactRevision]
+}
+
+// compile-time interface check
+var _ RevisionRepository = &MemoryRevisionRepository{}
+
+func NewMemoryRevisionRepository() *MemoryRevisionRepository {
+	return &MemoryRevisionRepository{
+		collection: database.NewMemoryCollection[ArtifactRevision](),
+	}
+}
+
+func (repo *MemoryRevisionRepository) Create(ctx context.Context, revision ArtifactRevision) (*ArtifactRevision, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	revisions, err := repo.List(ctx, revision.ArtifactID)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.ID = primitive.NewObjectID()
+	revision.Revision = len(revisions) + 1
+	if err := repo.collection.Insert(revision.ID, revision); err != nil {
+		return nil, err
+	}
+	return repo.collection.Get(revision.ID)
+}
+
+func (repo *MemoryRevisionRepository) List(ctx context.Context, artifactID primitive.ObjectID) ([]ArtifactRevision, error) {
+	return repo.collection.Find(func(revision ArtifactRevision) bool {
+		return revision.ArtifactID == artifactID
+	})
+}
+
+func (repo *MemoryRevisionRepository) Get(ctx context.Context, artifactID primitive.ObjectID, revision int) (*ArtifactRevision, error) {
+	revisions, err := repo.collection.Find(func(artifactRevision ArtifactRevision) bool {
+		return artifactRevision.ArtifactID == artifactID && artifactRevision.Revision == revision
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, database.ErrNotFound
+	}
+	return &revisions[0], nil
+}
@@ -0,0 +1,249 @@
+package artifacts
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// MongoRepository stores Artifacts in the artifactdb database
+type MongoRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ Repository = &MongoRepository{}
+
+func NewMongoRepository(client *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		collection: client.Database(ArtifactDbName).Collection(ArtifactColName),
+	}
+}
+
+func (repo *MongoRepository) Create(ctx context.Context, artifact Artifact) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, artifact)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoRepository) List(ctx context.Context) ([]Artifact, error) {
+	return repo.find(ctx, bson.M{})
+}
+
+func (repo *MongoRepository) Search(ctx context.Context, filter database.SearchFilter) ([]Artifact, error) {
+	return repo.find(ctx, searchQuery(filter))
+}
+
+// Build the query for a search filter, matching the key on the artifact or within its metadata
+func searchQuery(filter database.SearchFilter) bson.M {
+	query := bson.M{}
+	if filter.SearchKey != "" && filter.SearchValue != "" {
+		if filter.SearchVerb == "contains" {
+			query["$or"] = []bson.M{
+				{filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}},
+				{"artifactMetadata." + filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}},
+			}
+		} else {
+			query["$or"] = []bson.M{
+				{filter.SearchKey: filter.SearchValue},
+				{"artifactMetadata." + filter.SearchKey: filter.SearchValue},
+			}
+		}
+	}
+	return query
+}
+
+func (repo *MongoRepository) Get(ctx context.Context, id primitive.ObjectID) (*Artifact, error) {
+	var artifact Artifact
+	err := repo.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&artifact)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+func (repo *MongoRepository) Update(ctx context.Context, id primitive.ObjectID, artifact Artifact) error {
+	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
+	update := bson.M{
+		"$set": bson.M{
+			"name":             artifact.Name,
+			"description":      artifact.Description,
+			"artifactType":     artifact.ArtifactType,
+			"artifactFamily":   artifact.ArtifactFamily,
+			"artifactMetadata": artifact.ArtifactMetadata,
+			"dependencies":     artifact.Dependencies,
+			"updatedAt":        artifact.UpdatedAt,
+		},
+	}
+
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *MongoRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (repo *MongoRepository) AddPromotion(ctx context.Context, id primitive.ObjectID, promotion Promotion) error {
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$push": bson.M{"promotions": promotion}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *MongoRepository) ListPromotedTo(ctx context.Context, environment string) ([]Artifact, error) {
+	return repo.find(ctx, bson.M{"promotions.environment": environment})
+}
+
+func (repo *MongoRepository) Each(ctx context.Context, filter *database.SearchFilter, fn func(Artifact) error) error {
+	query := bson.M{}
+	if filter != nil {
+		query = searchQuery(*filter)
+	}
+
+	cursor, err := repo.collection.Find(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var artifact Artifact
+		if err := cursor.Decode(&artifact); err != nil {
+			log.Println("Error decoding artifact:", err)
+			continue
+		}
+		if err := fn(artifact); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (repo *MongoRepository) find(ctx context.Context, query bson.M) ([]Artifact, error) {
+	cursor, err := repo.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []Artifact
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var artifact Artifact
+		if err := cursor.Decode(&artifact); err != nil {
+			log.Println("Error decoding artifact:", err)
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, nil
+}
+
+// MongoRevisionRepository stores Artifact revisions in the artifactdb database
+type MongoRevisionRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ RevisionRepository = &MongoRevisionRepository{}
+
+// Number of times to retry when another writer claims the same revision number
+const revisionInsertAttempts = 5
+
+func NewMongoRevisionRepository(client *mongo.Client) *MongoRevisionRepository {
+	collection := client.Database(ArtifactDbName).Collection(ArtifactRevisionColName)
+
+	// Revision numbers must be unique per artifact, the index turns a concurrent update into a retry
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "artifactId", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating artifact revision index:", err)
+	}
+
+	return &MongoRevisionRepository{
+		collection: collection,
+	}
+}
+
+func (repo *MongoRevisionRepository) Create(ctx context.Context, revision ArtifactRevision) (*ArtifactRevision, error) {
+	var err error
+	for attempt := 0; attempt < revisionInsertAttempts; attempt++ {
+		var latest ArtifactRevision
+		opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+		err = repo.collection.FindOne(ctx, bson.M{"artifactId": revision.ArtifactID}, opts).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		revision.Revision = latest.Revision + 1
+
+		var result *mongo.InsertOneResult
+		result, err = repo.collection.InsertOne(ctx, revision)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		revision.ID = result.InsertedID.(primitive.ObjectID)
+		return &revision, nil
+	}
+	return nil, err
+}
+
+func (repo *MongoRevisionRepository) List(ctx context.Context, artifactID primitive.ObjectID) ([]ArtifactRevision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: 1}})
+	cursor, err := repo.collection.Find(ctx, bson.M{"artifactId": artifactID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []ArtifactRevision
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var revision ArtifactRevision
+		if err := cursor.Decode(&revision); err != nil {
+			log.Println("Error decoding artifact revision:", err)
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+func (repo *MongoRevisionRepository) Get(ctx context.Context, artifactID primitive.ObjectID, revision int) (*ArtifactRevision, error) {
+	var artifactRevision ArtifactRevision
+	err := repo.collection.FindOne(ctx, bson.M{"artifactId": artifactID, "revision": revision}).Decode(&artifactRevision)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &artifactRevision, nil
+}
@@ -0,0 +1,58 @@
+package artifacts
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// Repository is the storage used by the Artifact handlers
+type Repository interface {
+	Create(ctx context.Context, artifact Artifact) (primitive.ObjectID, error)
+	List(ctx context.Context) ([]Artifact, error)
+	Search(ctx context.Context, filter database.SearchFilter) ([]Artifact, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*Artifact, error)
+	Update(ctx context.Context, id primitive.ObjectID, artifact Artifact) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	AddPromotion(ctx context.Context, id primitive.ObjectID, promotion Promotion) error
+	ListPromotedTo(ctx context.Context, environment string) ([]Artifact, error)
+	// Each calls fn with every artifact matching the filter (every artifact when nil) without loading them all at once
+	Each(ctx context.Context, filter *database.SearchFilter, fn func(Artifact) error) error
+}
+
+// RevisionRepository is the append-only storage for Artifact revisions
+type RevisionRepository interface {
+	// Create stores the revision under the next revision number for its artifact
+	Create(ctx context.Context, revision ArtifactRevision) (*ArtifactRevision, error)
+	List(ctx context.Context, artifactID primitive.ObjectID) ([]ArtifactRevision, error)
+	Get(ctx context.Context, artifactID primitive.ObjectID, revision int) (*ArtifactRevision, error)
+}
+
+// Repositories for the configured backend, created on first use
+var repository Repository
+var revisionRepository RevisionRepository
+var repositoryOnce sync.Once
+
+func setupRepositories() {
+	if database.GetBackend() == database.MemoryBackend {
+		repository = NewMemoryRepository()
+		revisionRepository = NewMemoryRevisionRepository()
+	} else {
+		client, _ := database.SetupMongoDbClient()
+		repository = NewMongoRepository(client)
+		revisionRepository = NewMongoRevisionRepository(client)
+	}
+}
+
+// Get the Artifact repository for the configured backend
+func GetRepository() Repository {
+	repositoryOnce.Do(setupRepositories)
+	return repository
+}
+
+// Get the Artifact revision repository for the configured backend
+func GetRevisionRepository() RevisionRepository {
+	repositoryOnce.Do(setupRepositories)
+	return revisionRepository
+}
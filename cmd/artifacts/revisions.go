@@ -0,0 +1,111 @@
+package artifacts
+
+import (
+	auth "artifactflow.com/m/v2/cmd/auth"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ArtifactRevision is an immutable snapshot of an artifact, recorded on every create & update
+type ArtifactRevision struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ArtifactID primitive.ObjectID `json:"artifactId" bson:"artifactId"`
+	Revision   int                `json:"revision" bson:"revision"`   // 1, 2, 3... per artifact
+	Timestamp  time.Time          `json:"timestamp" bson:"timestamp"` // when the change was made
+	Actor      string             `json:"actor" bson:"actor"`         // user that made the change
+	Snapshot   Artifact           `json:"snapshot" bson:"snapshot"`   // the full artifact after the change
+}
+
+// Collection for Artifact revisions
+const ArtifactRevisionColName = "artifactrevisions"
+
+// Record a new revision holding the current state of the artifact
+func RecordRevision(ctx context.Context, artifact Artifact, actor string) (*ArtifactRevision, error) {
+	revision := ArtifactRevision{
+		ArtifactID: artifact.ID,
+		Timestamp:  time.Now().UTC(),
+		Actor:      actor,
+		Snapshot:   artifact,
+	}
+	return GetRevisionRepository().Create(ctx, revision)
+}
+
+// Get all revisions of an artifact, oldest first
+func GetArtifactRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting all revisions of a specific artifact record")
+	params := mux.Vars(r)
+
+	// Convert the string ID to an ObjectID if not already
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid Artifact ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	revisions, err := GetRevisionRepository().List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Unable to retrieve artifact revisions", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// Get a specific revision of an artifact
+func GetArtifactRevision(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting a specific revision of an artifact record")
+	params := mux.Vars(r)
+
+	// Convert the string ID to an ObjectID if not already
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid Artifact ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	rev, err := strconv.Atoi(params["rev"])
+	if err != nil || rev < 1 {
+		http.Error(w, "Invalid Revision Number", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	revision, err := GetRevisionRepository().Get(r.Context(), id, rev)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find that revision of the artifact", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve artifact revision", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(revision)
+}
+
+// Used by the create & update handlers, the history is part of the change so a failure to record it fails the request
+// Writes the error response when the revision can't be stored
+func recordRevision(w http.ResponseWriter, r *http.Request, artifact Artifact) bool {
+	if _, err := RecordRevision(r.Context(), artifact, auth.GetActor(r)); err != nil {
+		http.Error(w, "The artifact was saved but its revision could not be recorded", http.StatusInternalServerError)
+		log.Println("Error recording artifact revision:", err)
+		return false
+	}
+	return true
+}
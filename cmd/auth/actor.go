@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Recorded against changes when authentication is disabled or the caller could not be identified
+const AnonymousActor = "anonymous"
+
+// Key for the authenticated user within the request context
+type actorContextKey struct{}
+
+// Attach the authenticated user to the request for the handlers further down the chain
+func withActor(r *http.Request, actor string) *http.Request {
+	if actor == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), actorContextKey{}, actor))
+}
+
+// Get the authenticated user (email or API key owner) that made the request
+func GetActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorContextKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
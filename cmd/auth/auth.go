@@ -10,8 +10,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"log"
@@ -54,9 +52,6 @@ type ApiKey struct {
 	GeneratedDate time.Time          `json:"generatedDate,omitempty" bson:"generatedDate,omitempty"`
 }
 
-// MongoDB client
-var client, _ = database.SetupMongoDbClient()
-
 func SetupOauthProvider() bool {
 
 	// Set up Google OAuth2 configuration
@@ -289,11 +284,16 @@ func Middleware(next http.Handler) http.Handler {
 		if r.URL.Path != "/health" && r.URL.Path != "/auth/login" && r.URL.Path != "/auth/callback" {
  
 			var err error
+			var actor string
 
 			if (r.Header.Get("ArtifactFlow-Key") != "") {
-				err = validateApiKey(w, r)
+				actor, err = validateApiKey(w, r)
 			} else if (r.Header.Get("Authorization") != "") {
-				_, err = getTokenClaims(w, r)
+				var claims *CustomClaims
+				claims, err = getTokenClaims(w, r)
+				if err == nil {
+					actor = claims.Email
+				}
 			}
 
 			if err != nil {
@@ -308,15 +308,13 @@ func Middleware(next http.Handler) http.Handler {
 					}
 
 					// Retrieve the user ID from the session
-					_, ok := session.Values["emailID"].(string)
+					emailID, ok := session.Values["emailID"].(string)
 					if !ok {
 						http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-					return
 						return
 					}
 
-					next.ServeHTTP(w, r)
+					next.ServeHTTP(w, withActor(r, emailID))
 					return
 
 				}
@@ -325,6 +323,8 @@ func Middleware(next http.Handler) http.Handler {
 				return
 			}
 
+			r = withActor(r, actor)
+
 		}
 
 		next.ServeHTTP(w, r)
@@ -337,26 +337,22 @@ func generateAPIKey() (string, error) {
 	return apiKey, nil
 }
 
-func validateApiKey(w http.ResponseWriter, r *http.Request) (error) {
+// Returns the user the API key was generated for
+func validateApiKey(w http.ResponseWriter, r *http.Request) (string, error) {
 
     apiKey := r.Header.Get("ArtifactFlow-Key")
 
-	// Access the "authdb" database and "tokens" collection
-	collection := client.Database("authdb").Collection("tokens")
-
 	// Query the database for the API key
-	filter := bson.M{"apikey": apiKey}
-	var result ApiKey
-	err := collection.FindOne(r.Context(), filter).Decode(&result)
+	result, err := GetRepository().GetByKey(r.Context(), apiKey)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return errors.New("invalid API key")
+		if err == database.ErrNotFound {
+			return "", errors.New("invalid API key")
 		}
-		return errors.New("error occurred while querying the database")
+		return "", errors.New("error occurred while querying the database")
 	}
 
 	// API key exists and is valid
-	return nil
+	return result.UserID, nil
 
 }
 
@@ -377,15 +373,13 @@ func ApiKeyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	apiKey.GeneratedDate = time.Now() // Set the Generated Date
 
-	collection := client.Database(authDbName).Collection(authTokenColName)
-	result, err := collection.InsertOne(r.Context(), apiKey)
+	apiKey.ID, err = GetRepository().Create(r.Context(), apiKey)
 	if err != nil {
 		http.Error(w, "Unable to insert the record into the database", 417)
 		log.Println(err)
 		return
 	}
 
-	apiKey.ID = result.InsertedID.(primitive.ObjectID)
 	json.NewEncoder(w).Encode(apiKey)
 
 	// Api Key that can be used to call Artifact Flow
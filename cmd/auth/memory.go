@@ -0,0 +1,41 @@
+package auth
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps API keys in process memory, used with DB_BACKEND=memory
+type MemoryRepository struct {
+	collection *database.MemoryCollection[ApiKey]
+}
+
+// compile-time interface check
+var _ Repository = &MemoryRepository{}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		collection: database.NewMemoryCollection[ApiKey](),
+	}
+}
+
+func (repo *MemoryRepository) Create(ctx context.Context, apiKey ApiKey) (primitive.ObjectID, error) {
+	if apiKey.ID.IsZero() {
+		apiKey.ID = primitive.NewObjectID()
+	}
+	return apiKey.ID, repo.collection.Insert(apiKey.ID, apiKey)
+}
+
+func (repo *MemoryRepository) GetByKey(ctx context.Context, key string) (*ApiKey, error) {
+	apiKeys, err := repo.collection.Find(func(apiKey ApiKey) bool {
+		return apiKey.Key == key
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(apiKeys) == 0 {
+		return nil, database.ErrNotFound
+	}
+	return &apiKeys[0], nil
+}
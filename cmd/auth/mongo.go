@@ -0,0 +1,43 @@
+package auth
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoRepository stores API keys in the authdb database
+type MongoRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ Repository = &MongoRepository{}
+
+func NewMongoRepository(client *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		collection: client.Database(authDbName).Collection(authTokenColName),
+	}
+}
+
+func (repo *MongoRepository) Create(ctx context.Context, apiKey ApiKey) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, apiKey)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoRepository) GetByKey(ctx context.Context, key string) (*ApiKey, error) {
+	var apiKey ApiKey
+	err := repo.collection.FindOne(ctx, bson.M{"apikey": key}).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// Repository is the storage used for generated API keys
+type Repository interface {
+	Create(ctx context.Context, apiKey ApiKey) (primitive.ObjectID, error)
+	GetByKey(ctx context.Context, key string) (*ApiKey, error)
+}
+
+// Repository for the configured backend, created on first use
+var repository Repository
+var repositoryOnce sync.Once
+
+// Get the API key repository for the configured backend
+func GetRepository() Repository {
+	repositoryOnce.Do(func() {
+		if database.GetBackend() == database.MemoryBackend {
+			repository = NewMemoryRepository()
+		} else {
+			client, _ := database.SetupMongoDbClient()
+			repository = NewMongoRepository(client)
+		}
+	})
+	return repository
+}
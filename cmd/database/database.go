@@ -2,15 +2,18 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"os"
+	"sync"
 )
 
-// MongoDB client
+// MongoDB client, shared by every repository
 var client *mongo.Client
+var clientMutex sync.Mutex
 
 // Database Names
 const artifactDbName = "artifactdb"
@@ -20,6 +23,34 @@ const authDbName = "authdb"
 const artifactColName = "artifacts"
 const authTokenColName = "tokens"
 
+// Storage backends selectable with DB_BACKEND
+const MongoBackend = "mongodb"
+const MemoryBackend = "memory"
+
+// Returned by every repository when the requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Returned by the in-memory repositories when a record is inserted with an ID that is already stored
+var ErrDuplicateID = errors.New("record with that ID already exists")
+
+// Returned by conditional updates when the record was changed since it was read
+var ErrConflict = errors.New("record was changed by another request")
+
+// Search filter accepted by the search endpoints
+type SearchFilter struct {
+	SearchKey   string `json:"searchKey"`
+	SearchValue string `json:"searchValue"`
+	SearchVerb  string `json:"searchVerb"`
+}
+
+// Get the configured storage backend, defaults to MongoDB
+func GetBackend() string {
+	if os.Getenv("DB_BACKEND") == MemoryBackend {
+		return MemoryBackend
+	}
+	return MongoBackend
+}
+
 // Get connection string
 func GetConnectionString() string {
 	username := os.Getenv("DB_USERNAME")
@@ -41,24 +72,43 @@ func GetConnectionString() string {
 	return "mongodb://localhost:27017"
 }
 
+// Prepare the configured storage backend, only MongoDB needs a live connection
+func SetupDatabase() bool {
+	if GetBackend() == MemoryBackend {
+		fmt.Println("Warning: DB_BACKEND set to memory, all records will be lost when the server stops.")
+		return true
+	}
+
+	_, ok := SetupMongoDbClient()
+	return ok
+}
+
 func SetupMongoDbClient() (*mongo.Client, bool) {
 
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+
+	// Reuse the existing client rather than opening a new connection pool per caller
+	if client != nil {
+		return client, true
+	}
+
 	connectionString := GetConnectionString()
 
 	clientOptions := options.Client().ApplyURI(connectionString)
-	var err error
-	client, err = mongo.Connect(nil, clientOptions)
+	newClient, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
 		log.Fatal(err)
-		return client, false
+		return nil, false
 	}
 
-	err = client.Ping(context.Background(), nil)
+	err = newClient.Ping(context.Background(), nil)
 	if err != nil {
 		log.Fatal("Error: Unable to connect to the MongoDB database.")
-		return client, false
+		return nil, false
 	}
 	fmt.Println("Info: Connected to the MongoDB database successfully.")
+	client = newClient
 	return client, true
 
 }
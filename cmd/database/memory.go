@@ -0,0 +1,246 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MemoryCollection is an in-memory stand-in for a MongoDB collection
+// Records are round-tripped through BSON so callers see the same types they would get back from MongoDB
+type MemoryCollection[T any] struct {
+	mutex   sync.RWMutex
+	ids     []primitive.ObjectID // insertion order, to match MongoDB natural ordering
+	records map[primitive.ObjectID][]byte
+}
+
+func NewMemoryCollection[T any]() *MemoryCollection[T] {
+	return &MemoryCollection[T]{
+		records: make(map[primitive.ObjectID][]byte),
+	}
+}
+
+// Insert a record under the given ID
+func (c *MemoryCollection[T]) Insert(id primitive.ObjectID, record T) error {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.records[id]; ok {
+		return ErrDuplicateID
+	}
+
+	c.ids = append(c.ids, id)
+	c.records[id] = raw
+	return nil
+}
+
+// Get a copy of the record with the given ID
+func (c *MemoryCollection[T]) Get(id primitive.ObjectID) (*T, error) {
+	c.mutex.RLock()
+	raw, ok := c.records[id]
+	c.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	var record T
+	if err := bson.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Find copies of all records accepted by match, a nil match returns every record
+func (c *MemoryCollection[T]) Find(match func(T) bool) ([]T, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var records []T
+	for _, id := range c.ids {
+		var record T
+		if err := bson.Unmarshal(c.records[id], &record); err != nil {
+			return nil, err
+		}
+		if match == nil || match(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Call fn with a copy of each record accepted by match, one at a time, stopping at the first error
+// Records are decoded as they are visited, so fn may read or write the collection
+func (c *MemoryCollection[T]) Each(match func(T) bool, fn func(T) error) error {
+	c.mutex.RLock()
+	ids := append([]primitive.ObjectID{}, c.ids...)
+	c.mutex.RUnlock()
+
+	for _, id := range ids {
+		record, err := c.Get(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if match != nil && !match(*record) {
+			continue
+		}
+		if err := fn(*record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update the record with the given ID in place
+func (c *MemoryCollection[T]) Update(id primitive.ObjectID, apply func(*T)) error {
+	return c.UpdateIf(id, nil, apply)
+}
+
+// Update the record with the given ID in place if match accepts it, ErrConflict when it doesn't
+// A nil match accepts every record
+func (c *MemoryCollection[T]) UpdateIf(id primitive.ObjectID, match func(T) bool, apply func(*T)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	raw, ok := c.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	var record T
+	if err := bson.Unmarshal(raw, &record); err != nil {
+		return err
+	}
+	if match != nil && !match(record) {
+		return ErrConflict
+	}
+
+	apply(&record)
+
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	c.records[id] = raw
+	return nil
+}
+
+// Delete the record with the given ID, deleting a missing record is not an error (as with MongoDB)
+func (c *MemoryCollection[T]) Delete(id primitive.ObjectID) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.records[id]; !ok {
+		return nil
+	}
+
+	delete(c.records, id)
+	for i, existing := range c.ids {
+		if existing == id {
+			c.ids = append(c.ids[:i], c.ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Check a record against a search filter the same way the MongoDB repositories build their queries
+// Any of the given keys matching is enough, an empty filter matches everything
+func MatchesSearch(record any, filter SearchFilter, keys ...string) (bool, error) {
+	if filter.SearchKey == "" || filter.SearchValue == "" {
+		return true, nil
+	}
+
+	var pattern *regexp.Regexp
+	if filter.SearchVerb == "contains" {
+		var err error
+		pattern, err = regexp.Compile("(?i)" + filter.SearchValue)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		value, ok := LookupPath(document, key)
+		if !ok {
+			continue
+		}
+
+		// MongoDB matches an array field when any of its elements match
+		values := []any{value}
+		if array, ok := value.(primitive.A); ok {
+			values = array
+		}
+
+		for _, v := range values {
+			str, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if pattern != nil && pattern.MatchString(str) {
+				return true, nil
+			}
+			if pattern == nil && str == filter.SearchValue {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Resolve a dot separated key (e.g. environments.dev) against a decoded BSON document
+func LookupPath(document any, key string) (any, bool) {
+	current := document
+	for _, part := range strings.Split(key, ".") {
+		switch node := current.(type) {
+		case bson.M:
+			value, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case map[string]any:
+			value, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case bson.D:
+			found := false
+			for _, element := range node {
+				if element.Key == part {
+					current = element.Value
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
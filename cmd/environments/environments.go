@@ -0,0 +1,265 @@
+package environments
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Environment is a stage of the promotion chain, e.g. dev -> preprod -> prod
+type Environment struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name         string             `json:"name,omitempty" bson:"name,omitempty"`                 // preprod
+	Description  string             `json:"description,omitempty" bson:"description,omitempty"`   // Pre-production cluster
+	PromotesFrom string             `json:"promotesFrom,omitempty" bson:"promotesFrom,omitempty"` // dev, empty for the start of the chain
+}
+
+// Database & Collection for Environments
+const environmentDbName = "environmentdb"
+const environmentColName = "environments"
+
+// Create an Environment
+func CreateEnvironment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Creating new Environment")
+	var environment Environment
+	err := json.NewDecoder(r.Body).Decode(&environment)
+
+	if err != nil {
+		http.Error(w, "Unable to decode json into environment", 422)
+		log.Println(err)
+		return
+	}
+
+	if environment.Name == "" {
+		http.Error(w, "Environment name is required", 422)
+		return
+	}
+
+	if err := checkPromotionChain(r.Context(), environment.Name, environment.PromotesFrom); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	environment.ID, err = GetRepository().Create(r.Context(), environment)
+	if err == database.ErrDuplicateID {
+		http.Error(w, "An environment with that name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to insert the environment record into the database", 417)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(environment)
+}
+
+// Get all Environments, in promotion chain order
+func GetEnvironments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting all Environments")
+
+	environments, err := GetRepository().List(r.Context())
+	if err != nil {
+		http.Error(w, "Unable to check Environment collection", 500)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(orderByPromotionChain(environments))
+}
+
+// Get a specific Environment
+func GetEnvironment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting a specific environment record")
+	params := mux.Vars(r)
+
+	environment, err := GetRepository().Get(r.Context(), params["name"])
+	if err != nil {
+		http.Error(w, "Unable to find environment with that name", http.StatusNotFound)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(environment)
+}
+
+// Update an Environment, the name cannot be changed as promotions refer to it
+func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Updating a specific environment record")
+	params := mux.Vars(r)
+	name := params["name"]
+
+	var environment Environment
+	if err := json.NewDecoder(r.Body).Decode(&environment); err != nil {
+		http.Error(w, "Unable to decode json into environment", 422)
+		log.Println(err)
+		return
+	}
+
+	if err := checkPromotionChain(r.Context(), name, environment.PromotesFrom); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	err := GetRepository().Update(r.Context(), name, environment)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find environment with that name", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to update the environment record", 500)
+		log.Println(err)
+		return
+	}
+
+	updated, err := GetRepository().Get(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Unable to find environment with that name", http.StatusNotFound)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(updated)
+}
+
+// Delete an Environment, as long as no other environment promotes from it
+func DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Deleting a specific environment record")
+	params := mux.Vars(r)
+	name := params["name"]
+
+	environments, err := GetRepository().List(r.Context())
+	if err != nil {
+		http.Error(w, "Unable to check Environment collection", 500)
+		log.Println(err)
+		return
+	}
+
+	for _, environment := range environments {
+		if environment.PromotesFrom == name {
+			http.Error(w, fmt.Sprintf("Environment %s promotes from %s, update it before deleting", environment.Name, name), http.StatusConflict)
+			return
+		}
+	}
+
+	err = GetRepository().Delete(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Unable to purge selected record out of the database", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode("Environment record deleted successfully.")
+}
+
+// Get all artifacts currently promoted into an Environment
+func GetEnvironmentArtifacts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting all artifacts promoted to a specific environment")
+	params := mux.Vars(r)
+	name := params["name"]
+
+	if _, err := GetRepository().Get(r.Context(), name); err != nil {
+		http.Error(w, "Unable to find environment with that name", http.StatusNotFound)
+		log.Println(err)
+		return
+	}
+
+	promoted, err := artifacts.GetRepository().ListPromotedTo(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(currentlyPromoted(promoted, name))
+}
+
+// ------------------------------------------------------------------------------------------
+// Supporting Functions
+// ------------------------------------------------------------------------------------------
+
+// Keep the most recently promoted artifact of each name & family, earlier promotions have been superseded
+func currentlyPromoted(promoted []artifacts.Artifact, environment string) []artifacts.Artifact {
+	type release struct{ name, family string }
+
+	latest := make(map[release]int)
+	var current []artifacts.Artifact
+	for _, artifact := range promoted {
+		key := release{artifact.Name, artifact.ArtifactFamily}
+		i, seen := latest[key]
+		if !seen {
+			latest[key] = len(current)
+			current = append(current, artifact)
+			continue
+		}
+		if !artifact.LatestPromotion(environment).Timestamp.Before(current[i].LatestPromotion(environment).Timestamp) {
+			current[i] = artifact
+		}
+	}
+	return current
+}
+
+// Check the environment an environment promotes from exists & the chain doesn't loop back on itself
+func checkPromotionChain(ctx context.Context, name string, promotesFrom string) error {
+	visited := map[string]bool{name: true}
+
+	for current := promotesFrom; current != ""; {
+		if visited[current] {
+			return fmt.Errorf("Environment %s cannot promote from %s, the promotion chain would loop", name, promotesFrom)
+		}
+		visited[current] = true
+
+		environment, err := GetRepository().Get(ctx, current)
+		if err == database.ErrNotFound {
+			return fmt.Errorf("Environment %s to promote from was not found", current)
+		}
+		if err != nil {
+			return err
+		}
+
+		current = environment.PromotesFrom
+	}
+
+	return nil
+}
+
+// Sort environments by their distance from the start of the promotion chain
+func orderByPromotionChain(environments []Environment) []Environment {
+	byName := make(map[string]Environment)
+	for _, environment := range environments {
+		byName[environment.Name] = environment
+	}
+
+	depth := func(environment Environment) int {
+		steps := 0
+		for current := environment.PromotesFrom; current != "" && steps <= len(environments); steps++ {
+			current = byName[current].PromotesFrom
+		}
+		return steps
+	}
+
+	sort.SliceStable(environments, func(i, j int) bool {
+		return depth(environments[i]) < depth(environments[j])
+	})
+
+	return environments
+}
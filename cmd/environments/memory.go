@@ -0,0 +1,76 @@
+package environments
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// MemoryRepository keeps Environments in process memory, used with DB_BACKEND=memory
+type MemoryRepository struct {
+	mutex      sync.Mutex // keeps names unique
+	collection *database.MemoryCollection[Environment]
+}
+
+// compile-time interface check
+var _ Repository = &MemoryRepository{}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		collection: database.NewMemoryCollection[Environment](),
+	}
+}
+
+func (repo *MemoryRepository) Create(ctx context.Context, environment Environment) (primitive.ObjectID, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, err := repo.Get(ctx, environment.Name); err == nil {
+		return primitive.NilObjectID, database.ErrDuplicateID
+	}
+
+	if environment.ID.IsZero() {
+		environment.ID = primitive.NewObjectID()
+	}
+	return environment.ID, repo.collection.Insert(environment.ID, environment)
+}
+
+func (repo *MemoryRepository) List(ctx context.Context) ([]Environment, error) {
+	return repo.collection.Find(nil)
+}
+
+func (repo *MemoryRepository) Get(ctx context.Context, name string) (*Environment, error) {
+	environments, err := repo.collection.Find(func(environment Environment) bool {
+		return environment.Name == name
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(environments) == 0 {
+		return nil, database.ErrNotFound
+	}
+	return &environments[0], nil
+}
+
+func (repo *MemoryRepository) Update(ctx context.Context, name string, environment Environment) error {
+	stored, err := repo.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	return repo.collection.Update(stored.ID, func(stored *Environment) {
+		stored.Description = environment.Description
+		stored.PromotesFrom = environment.PromotesFrom
+	})
+}
+
+func (repo *MemoryRepository) Delete(ctx context.Context, name string) error {
+	stored, err := repo.Get(ctx, name)
+	if err == database.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return repo.collection.Delete(stored.ID)
+}
@@ -0,0 +1,102 @@
+package environments
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// MongoRepository stores Environments in the environmentdb database
+type MongoRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ Repository = &MongoRepository{}
+
+func NewMongoRepository(client *mongo.Client) *MongoRepository {
+	collection := client.Database(environmentDbName).Collection(environmentColName)
+
+	// Environments are addressed by name so it must be unique
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating environment name index:", err)
+	}
+
+	return &MongoRepository{
+		collection: collection,
+	}
+}
+
+func (repo *MongoRepository) Create(ctx context.Context, environment Environment) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, environment)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, database.ErrDuplicateID
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoRepository) List(ctx context.Context) ([]Environment, error) {
+	cursor, err := repo.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var environments []Environment
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var environment Environment
+		if err := cursor.Decode(&environment); err != nil {
+			log.Println("Error decoding environment:", err)
+			continue
+		}
+		environments = append(environments, environment)
+	}
+
+	return environments, nil
+}
+
+func (repo *MongoRepository) Get(ctx context.Context, name string) (*Environment, error) {
+	var environment Environment
+	err := repo.collection.FindOne(ctx, bson.M{"name": name}).Decode(&environment)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+func (repo *MongoRepository) Update(ctx context.Context, name string, environment Environment) error {
+	update := bson.M{
+		"$set": bson.M{
+			"description":  environment.Description,
+			"promotesFrom": environment.PromotesFrom,
+		},
+	}
+
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"name": name}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *MongoRepository) Delete(ctx context.Context, name string) error {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"name": name})
+	return err
+}
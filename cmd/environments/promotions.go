@@ -0,0 +1,124 @@
+package environments
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	auth "artifactflow.com/m/v2/cmd/auth"
+	database "artifactflow.com/m/v2/cmd/database"
+	validation "artifactflow.com/m/v2/cmd/validation"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+type PromotionRequest struct {
+	ArtifactID  string `json:"artifactId"`
+	Environment string `json:"environment"`
+}
+
+type PromotionResult struct {
+	ArtifactId  string                       `json:"artifactId"`
+	Environment string                       `json:"environment"`
+	Promoted    bool                         `json:"promoted"`
+	Reason      string                       `json:"reason,omitempty"` // why the promotion was refused
+	Promotion   *artifacts.Promotion         `json:"promotion,omitempty"`
+	Validation  *validation.ValidationResult `json:"validation,omitempty"`
+}
+
+// Promote an artifact into an environment, if it has been promoted through the previous
+// environment of the chain & passes the rules mapped to the environment
+func CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Creating new Promotion")
+	var req PromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(req.ArtifactID)
+	if err != nil {
+		http.Error(w, "Invalid Artifact ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	artifact, err := artifacts.GetRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find artifact with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve artifact", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	environment, err := GetRepository().Get(r.Context(), req.Environment)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find environment with that name", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	result := PromotionResult{
+		ArtifactId:  req.ArtifactID,
+		Environment: environment.Name,
+	}
+
+	// The artifact has to have made it through the previous stage of the chain first
+	if environment.PromotesFrom != "" && artifact.LatestPromotion(environment.PromotesFrom) == nil {
+		result.Reason = fmt.Sprintf("artifact has not been promoted to %s, which %s promotes from", environment.PromotesFrom, environment.Name)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	result.Validation, err = validation.ValidateAndRecord(r, artifact, environment.Name, validation.SourcePromotion)
+	if err != nil {
+		http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if !result.Validation.PassesValidation {
+		result.Reason = fmt.Sprintf("artifact does not pass the validation rules for %s", environment.Name)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	promotion := artifacts.Promotion{
+		Environment: environment.Name,
+		Timestamp:   time.Now().UTC(),
+		Actor:       auth.GetActor(r),
+	}
+
+	// Record which revision of the artifact was promoted
+	revisions, err := artifacts.GetRevisionRepository().List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Unable to retrieve artifact revisions", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if len(revisions) > 0 {
+		promotion.Revision = revisions[len(revisions)-1].Revision
+	}
+
+	if err := artifacts.GetRepository().AddPromotion(r.Context(), id, promotion); err != nil {
+		http.Error(w, "Unable to record the promotion against the artifact", 417)
+		log.Println(err)
+		return
+	}
+
+	result.Promoted = true
+	result.Promotion = &promotion
+	json.NewEncoder(w).Encode(result)
+}
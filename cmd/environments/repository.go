@@ -0,0 +1,34 @@
+package environments
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// Repository is the storage used by the Environment handlers, environments are addressed by their unique name
+type Repository interface {
+	Create(ctx context.Context, environment Environment) (primitive.ObjectID, error)
+	List(ctx context.Context) ([]Environment, error)
+	Get(ctx context.Context, name string) (*Environment, error)
+	Update(ctx context.Context, name string, environment Environment) error
+	Delete(ctx context.Context, name string) error
+}
+
+// Repository for the configured backend, created on first use
+var repository Repository
+var repositoryOnce sync.Once
+
+// Get the Environment repository for the configured backend
+func GetRepository() Repository {
+	repositoryOnce.Do(func() {
+		if database.GetBackend() == database.MemoryBackend {
+			repository = NewMemoryRepository()
+		} else {
+			client, _ := database.SetupMongoDbClient()
+			repository = NewMongoRepository(client)
+		}
+	})
+	return repository
+}
@@ -4,6 +4,7 @@ import (
 	artifacts "artifactflow.com/m/v2/cmd/artifacts"
 	auth "artifactflow.com/m/v2/cmd/auth"
 	database "artifactflow.com/m/v2/cmd/database"
+	environments "artifactflow.com/m/v2/cmd/environments"
 	supporting "artifactflow.com/m/v2/cmd/supporting"
 	validation "artifactflow.com/m/v2/cmd/validation"
 	"fmt"
@@ -14,24 +15,24 @@ import (
 	"os"
 )
 
-// MongoDB client
-var client, _ = database.SetupMongoDbClient()
-
 func main() {
 
 	auth.Store = sessions.NewCookieStore(auth.Secret)
 
-	// Initialize MongoDB client
-	_, setupDatabaseClient := database.SetupMongoDbClient()
+	// Initialize the storage backend (MongoDB unless DB_BACKEND=memory)
+	setupDatabaseClient := database.SetupDatabase()
 
 	// Setup Oauth Provider
 	setupOauthProvider := auth.SetupOauthProvider()
 
 	if setupDatabaseClient == false || setupOauthProvider == false {
-		fmt.Sprintf("Error: Prereqs unable to be initialised:\n - Database Available: %v\n - Oauth Provider: %v", setupDatabaseClient, setupOauthProvider)
+		fmt.Printf("Error: Prereqs unable to be initialised:\n - Database Available: %v\n - Oauth Provider: %v\n", setupDatabaseClient, setupOauthProvider)
 		os.Exit(1)
 	}
 
+	// Reconcile the validation policy from POLICY_SYNC_DIR, if set
+	validation.StartPolicySync(validation.PolicySyncConfigFromEnv())
+
 	// Initialize router
 	router := mux.NewRouter()
 
@@ -45,12 +46,18 @@ func main() {
 	router.HandleFunc("/artifacts/{id}", artifacts.GetArtifact).Methods("GET")
 	router.HandleFunc("/artifacts/{id}", artifacts.UpdateArtifact).Methods("PUT")
 	router.HandleFunc("/artifacts/{id}", artifacts.DeleteArtifact).Methods("DELETE")
+	router.HandleFunc("/artifacts/{id}/revisions", artifacts.GetArtifactRevisions).Methods("GET")
+	router.HandleFunc("/artifacts/{id}/revisions/{rev}", artifacts.GetArtifactRevision).Methods("GET")
 
 	// API endpoints for Validation Rules
 	router.HandleFunc("/validation/rules", validation.CreateRule).Methods("POST")
 	router.HandleFunc("/validation/rules", validation.GetRules).Methods("GET")
 	router.HandleFunc("/validation/rules/{id}", validation.GetRule).Methods("GET")
 	router.HandleFunc("/validation/rules/search", validation.SearchRules).Methods("POST")
+	router.HandleFunc("/validation/rules/test", validation.TestUnsavedRule).Methods("POST")
+	router.HandleFunc("/validation/rules/{id}/test", validation.TestRule).Methods("POST")
+	router.HandleFunc("/validation/rules/{id}/versions", validation.GetRuleVersions).Methods("GET")
+	router.HandleFunc("/validation/rules/{id}/versions/{version}", validation.GetRuleVersion).Methods("GET")
 	router.HandleFunc("/validation/rules/{id}", validation.UpdateRule).Methods("PUT")
 	router.HandleFunc("/validation/rules/{id}", validation.DeleteRule).Methods("DELETE")
 
@@ -62,8 +69,37 @@ func main() {
 	router.HandleFunc("/validation/mappings/{id}", validation.UpdateRuleMapping).Methods("PUT")
 	router.HandleFunc("/validation/mappings/{id}", validation.DeleteRuleMapping).Methods("DELETE")
 
+	// API endpoints for Validation Policy bundles
+	router.HandleFunc("/validation/export", validation.ExportPolicy).Methods("GET")
+	router.HandleFunc("/validation/import", validation.ImportPolicy).Methods("POST")
+	router.HandleFunc("/validation/sync", validation.GetPolicySync).Methods("GET")
+	router.HandleFunc("/validation/sync", validation.TriggerPolicySync).Methods("POST")
+
+	// API endpoints for Waivers
+	router.HandleFunc("/validation/waivers", validation.CreateWaiver).Methods("POST")
+	router.HandleFunc("/validation/waivers", validation.GetWaivers).Methods("GET")
+	router.HandleFunc("/validation/waivers/{id}", validation.GetWaiver).Methods("GET")
+	router.HandleFunc("/validation/waivers/{id}", validation.DeleteWaiver).Methods("DELETE")
+
 	// API endpoints for Validation of Artifacts
 	router.HandleFunc("/validation/artifacts", validation.ValidateArtifact).Methods("POST")
+	router.HandleFunc("/validation/evaluate", validation.EvaluateInlineArtifact).Methods("POST")
+	router.HandleFunc("/validation/batch", validation.ValidateArtifacts).Methods("POST")
+	router.HandleFunc("/validation/simulate", validation.SimulatePolicy).Methods("POST")
+
+	// API endpoints for the Validation history
+	router.HandleFunc("/validation/results/{id}", validation.GetValidation).Methods("GET")
+	router.HandleFunc("/validation/results/artifacts/{id}", validation.GetArtifactValidations).Methods("GET")
+	router.HandleFunc("/validation/results/environments/{name}", validation.GetEnvironmentValidations).Methods("GET")
+
+	// API endpoints for Environments & Promotions
+	router.HandleFunc("/environments", environments.CreateEnvironment).Methods("POST")
+	router.HandleFunc("/environments", environments.GetEnvironments).Methods("GET")
+	router.HandleFunc("/environments/{name}", environments.GetEnvironment).Methods("GET")
+	router.HandleFunc("/environments/{name}", environments.UpdateEnvironment).Methods("PUT")
+	router.HandleFunc("/environments/{name}", environments.DeleteEnvironment).Methods("DELETE")
+	router.HandleFunc("/environments/{name}/artifacts", environments.GetEnvironmentArtifacts).Methods("GET")
+	router.HandleFunc("/promotions", environments.CreatePromotion).Methods("POST")
 
 	// Generate a Static API Key for Artifact-Flow
 	router.HandleFunc("/auth/apikey", auth.ApiKeyHandler).Methods("GET")
@@ -3,10 +3,13 @@ package main
 import (
 	artifacts "artifactflow.com/m/v2/cmd/artifacts"
 	database "artifactflow.com/m/v2/cmd/database"
+	environments "artifactflow.com/m/v2/cmd/environments"
+	validation "artifactflow.com/m/v2/cmd/validation"
 	"bytes"
-	//"context"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	//"go.mongodb.org/mongo-driver/bson"
 	"encoding/base64"
@@ -15,15 +18,29 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+
+	// Run against the in-memory backend unless a database backend was requested explicitly
+	if os.Getenv("DB_BACKEND") == "" {
+		os.Setenv("DB_BACKEND", database.MemoryBackend)
+	}
+
+	os.Exit(m.Run())
+}
+
 func TestGetArtifacts(t *testing.T) {
 
 	// Test for listing all artifacts
 
-	database.SetupMongoDbClient()
+	database.SetupDatabase()
 
 	// Create a mock request
 	req, err := http.NewRequest("GET", "/artifacts", nil)
@@ -55,7 +72,7 @@ func TestGetArtifacts(t *testing.T) {
 
 func TestArtifactCRUD(t *testing.T) {
 
-	database.SetupMongoDbClient()
+	database.SetupDatabase()
 
 	// --------------------------------------------------------------------
 	// [C] CREATE a new artifact
@@ -241,6 +258,13 @@ func compareSearchResult(searchReq *http.Request, expectedArtifact artifacts.Art
 		return false, fmt.Errorf("Expected 1 search result but got %d", len(searchResult))
 	}
 
+	// Timestamps are recorded by the server, so check they were set before comparing the rest of the record
+	if searchResult[0].CreatedAt == nil || searchResult[0].UpdatedAt == nil {
+		return false, fmt.Errorf("Search result is missing the server recorded timestamps: %+v", searchResult[0])
+	}
+	expectedArtifact.CreatedAt = searchResult[0].CreatedAt
+	expectedArtifact.UpdatedAt = searchResult[0].UpdatedAt
+
 	// Assert specific artifact details
 	if !reflect.DeepEqual(searchResult[0], expectedArtifact) {
 		return false, fmt.Errorf("Search result does not match the expected artifact:\nExpected: %+v\nActual: %+v", expectedArtifact, searchResult[0])
@@ -251,7 +275,7 @@ func compareSearchResult(searchReq *http.Request, expectedArtifact artifacts.Art
 
 func TestArtifactSearch(t *testing.T) {
 
-	database.SetupMongoDbClient()
+	database.SetupDatabase()
 
 	// --------------------------------------------------------------------
 	// [C] CREATE a new artifact to search for by unique search attributes
@@ -403,3 +427,3091 @@ func TestArtifactSearch(t *testing.T) {
 	// -------------------------------------------------------------------
 
 }
+
+func TestArtifactRevisions(t *testing.T) {
+
+	database.SetupDatabase()
+
+	// --------------------------------------------------------------------
+	// [C] CREATE a new artifact, which should record revision 1
+
+	artifactId := primitive.NewObjectID()
+
+	artifact := artifacts.Artifact{
+		ID:             artifactId,
+		Name:           "Revisioned Artifact",
+		ArtifactType:   "container",
+		ArtifactFamily: "test-family",
+		ArtifactMetadata: map[string]interface{}{
+			"coverage": 75,
+		},
+	}
+
+	body, err := json.Marshal(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/artifacts", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	artifacts.CreateArtifact(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// --------------------------------------------------------------------
+	// [U] UPDATE the artifact, which should record revision 2
+
+	artifact.ArtifactMetadata["coverage"] = 85
+
+	body, err = json.Marshal(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("PUT", "/artifacts/"+artifactId.Hex(), bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req = mux.SetURLVars(req, map[string]string{"id": artifactId.Hex()})
+
+	rr = httptest.NewRecorder()
+	artifacts.UpdateArtifact(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The response is the stored artifact, including the fields the server manages
+	var updated artifacts.Artifact
+	if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, updated.CreatedAt)
+	assert.NotNil(t, updated.UpdatedAt)
+	assert.Equal(t, float64(85), updated.ArtifactMetadata["coverage"])
+
+	// --------------------------------------------------------------------
+	// [R] READ the history, both snapshots should be kept
+
+	req, err = http.NewRequest("GET", "/artifacts/"+artifactId.Hex()+"/revisions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": artifactId.Hex()})
+
+	rr = httptest.NewRecorder()
+	artifacts.GetArtifactRevisions(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var revisions []artifacts.ArtifactRevision
+	if err := json.Unmarshal(rr.Body.Bytes(), &revisions); err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, 1, revisions[0].Revision)
+		assert.Equal(t, 2, revisions[1].Revision)
+		assert.Equal(t, float64(75), revisions[0].Snapshot.ArtifactMetadata["coverage"])
+		assert.Equal(t, float64(85), revisions[1].Snapshot.ArtifactMetadata["coverage"])
+		assert.Equal(t, "anonymous", revisions[1].Actor)
+	}
+
+	// --------------------------------------------------------------------
+	// [R] READ a single revision, and one that doesn't exist
+
+	req, err = http.NewRequest("GET", "/artifacts/"+artifactId.Hex()+"/revisions/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": artifactId.Hex(), "rev": "1"})
+
+	rr = httptest.NewRecorder()
+	artifacts.GetArtifactRevision(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var revision artifacts.ArtifactRevision
+	if err := json.Unmarshal(rr.Body.Bytes(), &revision); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Revisioned Artifact", revision.Snapshot.Name)
+
+	req = mux.SetURLVars(req, map[string]string{"id": artifactId.Hex(), "rev": "3"})
+
+	rr = httptest.NewRecorder()
+	artifacts.GetArtifactRevision(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// --------------------------------------------------------------------
+	// [U] UPDATE snapshots the artifact as written, server managed fields are kept
+
+	assert.NotNil(t, revisions[1].Snapshot.CreatedAt)
+	assert.Equal(t, artifactId, revisions[1].Snapshot.ID)
+	assert.Equal(t, "test-family", revisions[1].Snapshot.ArtifactFamily)
+
+	// --------------------------------------------------------------------
+	// [U] UPDATE an artifact that doesn't exist, nothing is recorded
+
+	missingId := primitive.NewObjectID()
+	rr = callHandler(t, artifacts.UpdateArtifact, "PUT", "/artifacts/"+missingId.Hex(), map[string]string{"id": missingId.Hex()}, artifact)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = callHandler(t, artifacts.GetArtifactRevisions, "GET", "/artifacts/"+missingId.Hex()+"/revisions", map[string]string{"id": missingId.Hex()}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "null", rr.Body.String())
+
+}
+
+// Call a handler with a JSON body & the given route variables, returning the recorded response
+func callHandler(t *testing.T, handler http.HandlerFunc, method string, url string, vars map[string]string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+// Delete every rule & mapping now & once the test ends, for tests that import or sync a whole policy
+// The store must belong to the tests, as with the memory backend or the CI database
+func clearPolicy(t *testing.T) {
+	deleteAll := func() {
+		ctx := context.Background()
+		mappings, err := validation.GetMappingRepository().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mapping := range mappings {
+			if err := validation.GetMappingRepository().Delete(ctx, mapping.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rules, err := validation.GetRuleRepository().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rule := range rules {
+			if err := validation.GetRuleRepository().Delete(ctx, rule.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	deleteAll()
+	t.Cleanup(deleteAll)
+}
+
+// Create a rule & map it, the mapping is given without its ruleId, returns the ID of the rule
+func createMappedRule(t *testing.T, rule map[string]interface{}, mapping map[string]interface{}) string {
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, rule)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var created validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping["ruleId"] = created.ID.Hex()
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, mapping)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	return created.ID.Hex()
+}
+
+func TestPromotionWorkflow(t *testing.T) {
+
+	database.SetupDatabase()
+
+	// --------------------------------------------------------------------
+	// Setup a dev -> preprod chain, with a coverage rule mapped to preprod
+
+	devName := "dev-" + generateRandomID(6)
+	preprodName := "preprod-" + generateRandomID(6)
+
+	rr := callHandler(t, environments.CreateEnvironment, "POST", "/environments", nil, environments.Environment{Name: devName})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, environments.CreateEnvironment, "POST", "/environments", nil, environments.Environment{Name: preprodName, PromotesFrom: devName})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Environments can't promote from an environment that doesn't exist
+	rr = callHandler(t, environments.CreateEnvironment, "POST", "/environments", nil, environments.Environment{Name: "prod-" + generateRandomID(6), PromotesFrom: "missing"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	// Or loop back on themselves
+	rr = callHandler(t, environments.UpdateEnvironment, "PUT", "/environments/"+devName, map[string]string{"name": devName}, environments.Environment{PromotesFrom: preprodName})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "Minimum Coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []map[string]interface{}{{"type": "min", "value": 80}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"enforced":     true,
+		"environments": map[string]interface{}{preprodName: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	artifactId := primitive.NewObjectID()
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifacts.Artifact{
+		ID:               artifactId,
+		Name:             "Promoted Artifact",
+		ArtifactMetadata: map[string]interface{}{"coverage": 75},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 1: Promotion to preprod is refused before the artifact reaches dev
+
+	promotion := environments.PromotionRequest{ArtifactID: artifactId.Hex(), Environment: preprodName}
+	rr = callHandler(t, environments.CreatePromotion, "POST", "/promotions", nil, promotion)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 2: Promotion to dev succeeds as no rules are mapped there
+
+	rr = callHandler(t, environments.CreatePromotion, "POST", "/promotions", nil, environments.PromotionRequest{ArtifactID: artifactId.Hex(), Environment: devName})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result environments.PromotionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, result.Promoted)
+	assert.Equal(t, 1, result.Promotion.Revision)
+
+	// --------------------------------------------------------------------
+	// Test 3: Promotion to preprod is refused as the coverage rule fails
+
+	rr = callHandler(t, environments.CreatePromotion, "POST", "/promotions", nil, promotion)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	result = environments.PromotionResult{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, result.Promoted)
+	assert.False(t, result.Validation.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Test 4: Once coverage is fixed the artifact can be promoted to preprod
+
+	rr = callHandler(t, artifacts.UpdateArtifact, "PUT", "/artifacts/"+artifactId.Hex(), map[string]string{"id": artifactId.Hex()}, artifacts.Artifact{
+		Name:             "Promoted Artifact",
+		ArtifactMetadata: map[string]interface{}{"coverage": 90},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, environments.CreatePromotion, "POST", "/promotions", nil, promotion)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 5: The artifact is listed against both environments
+
+	for _, name := range []string{devName, preprodName} {
+		rr = callHandler(t, environments.GetEnvironmentArtifacts, "GET", "/environments/"+name+"/artifacts", map[string]string{"name": name}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var promoted []artifacts.Artifact
+		if err := json.Unmarshal(rr.Body.Bytes(), &promoted); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, promoted, 1) {
+			assert.Equal(t, artifactId, promoted[0].ID)
+			assert.NotNil(t, promoted[0].LatestPromotion(name))
+		}
+	}
+
+	// --------------------------------------------------------------------
+	// Test 6: The validations made by the promotions into preprod are kept in its history
+
+	history, err := validation.GetResultRepository().ListByEnvironment(context.Background(), preprodName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, validation.SourcePromotion, history[0].Source)
+		assert.False(t, history[0].PassesValidation)
+		assert.True(t, history[1].PassesValidation)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 7: A newer build of the artifact promoted to dev replaces it there, preprod keeps the older build
+
+	newerId := primitive.NewObjectID()
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifacts.Artifact{
+		ID:               newerId,
+		Name:             "Promoted Artifact",
+		ArtifactMetadata: map[string]interface{}{"coverage": 95},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, environments.CreatePromotion, "POST", "/promotions", nil, environments.PromotionRequest{ArtifactID: newerId.Hex(), Environment: devName})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	for name, expected := range map[string]primitive.ObjectID{devName: newerId, preprodName: artifactId} {
+		rr = callHandler(t, environments.GetEnvironmentArtifacts, "GET", "/environments/"+name+"/artifacts", map[string]string{"name": name}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var promoted []artifacts.Artifact
+		if err := json.Unmarshal(rr.Body.Bytes(), &promoted); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, promoted, 1) {
+			assert.Equal(t, expected, promoted[0].ID)
+		}
+	}
+
+}
+
+func TestValidationWarnings(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "advisory-" + generateRandomID(6)
+
+	// --------------------------------------------------------------------
+	// Map an advisory & an enforced rule to the environment
+
+	coverageRule := createMappedRule(t, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	}, map[string]interface{}{"enforced": false, "environments": map[string]interface{}{environment: true}})
+	familyRule := createMappedRule(t, map[string]interface{}{
+		"ruleKey":    "artifactFamily",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "equal", "value": "approved"}},
+	}, map[string]interface{}{"enforced": true, "environments": map[string]interface{}{environment: true}})
+
+	validate := func(artifact artifacts.Artifact) validation.ValidationResult {
+		artifact.ID = primitive.NewObjectID()
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  artifact.ID.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: Failing only the advisory rule passes with a warning
+
+	result := validate(artifacts.Artifact{
+		ArtifactFamily:   "approved",
+		ArtifactMetadata: map[string]interface{}{"coverage": 50},
+	})
+	assert.True(t, result.PassesValidation)
+	assert.Empty(t, result.Violations)
+	assert.Contains(t, result.Warnings, coverageRule)
+
+	// --------------------------------------------------------------------
+	// Test 2: Failing the enforced rule fails, with the advisory failure still reported
+
+	result = validate(artifacts.Artifact{
+		ArtifactFamily:   "unapproved",
+		ArtifactMetadata: map[string]interface{}{"coverage": 50},
+	})
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, familyRule)
+	assert.Contains(t, result.Warnings, coverageRule)
+
+}
+
+// Build a rule limit, a nil value leaves the limit's value unset
+func ruleLimit(limitType string, value interface{}) validation.RuleLimit {
+	if value == nil {
+		return validation.RuleLimit{Type: limitType}
+	}
+	return validation.RuleLimit{Type: limitType, Value: &value}
+}
+
+func TestRuleLimitOperators(t *testing.T) {
+
+	artifact := artifacts.Artifact{
+		Name:           "Operator Artifact",
+		ArtifactFamily: "payments",
+		ArtifactMetadata: map[string]interface{}{
+			"license":   "MIT",
+			"criticals": float64(0),
+			"image":     "registry.example.com/payments:1.4.2",
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		limit  validation.RuleLimit
+		key    string
+		passes bool
+	}{
+		{"in passes", ruleLimit("in", []interface{}{"MIT", "Apache-2.0"}), "artifactMetadata.license", true},
+		{"in fails", ruleLimit("in", []interface{}{"Apache-2.0"}), "artifactMetadata.license", false},
+		{"in with numbers", ruleLimit("in", []interface{}{0, 1}), "artifactMetadata.criticals", true},
+		{"in without a list", ruleLimit("in", "MIT"), "artifactMetadata.license", false},
+		{"notIn passes", ruleLimit("notIn", []interface{}{"GPL-3.0"}), "artifactMetadata.license", true},
+		{"notIn fails", ruleLimit("notIn", []interface{}{"MIT"}), "artifactMetadata.license", false},
+		{"notEqual passes", ruleLimit("notEqual", "legacy"), "artifactFamily", true},
+		{"notEqual fails", ruleLimit("notEqual", "payments"), "artifactFamily", false},
+		{"matches passes", ruleLimit("matches", `^registry\.example\.com/`), "artifactMetadata.image", true},
+		{"matches fails", ruleLimit("matches", `^docker\.io/`), "artifactMetadata.image", false},
+		{"matches a number", ruleLimit("matches", `^0$`), "artifactMetadata.criticals", false},
+		{"matches invalid expression", ruleLimit("matches", `(`), "artifactMetadata.image", false},
+		{"exists passes", ruleLimit("exists", nil), "artifactMetadata.license", true},
+		{"exists fails", ruleLimit("exists", nil), "artifactMetadata.signature", false},
+		{"set passes", ruleLimit("set", nil), "name", true},
+		{"absent passes", ruleLimit("absent", nil), "artifactMetadata.signature", true},
+		{"absent fails", ruleLimit("absent", nil), "artifactMetadata.license", false},
+		{"unsupported type", ruleLimit("between", nil), "name", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.limit.Evaluate(artifact, testCase.key)
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+}
+
+func TestRuleLimitComparisons(t *testing.T) {
+
+	artifact := artifacts.Artifact{
+		Name: "Comparison Artifact",
+		ArtifactMetadata: map[string]interface{}{
+			"coverage":       79.9,
+			"coverageString": "80.5",
+			"criticals":      int32(0),
+			"downloads":      int64(9007199254740993), // not representable as a float64
+			"signed":         true,
+			"severity":       "high",
+		},
+	}
+
+	decimal, err := primitive.ParseDecimal128("80.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact.ArtifactMetadata["decimalCoverage"] = decimal // as decoded from a MongoDB Decimal128
+
+	testCases := []struct {
+		name   string
+		limit  validation.RuleLimit
+		key    string
+		passes bool
+	}{
+		{"fractional value below min", ruleLimit("min", 80), "artifactMetadata.coverage", false},
+		{"fractional value above max", ruleLimit("max", 79.8), "artifactMetadata.coverage", false},
+		{"fractional value within max", ruleLimit("max", 80), "artifactMetadata.coverage", true},
+		{"fractional limit", ruleLimit("min", 79.9), "artifactMetadata.coverage", true},
+		{"decimal string value", ruleLimit("min", 80), "artifactMetadata.coverageString", true},
+		{"decimal string limit", ruleLimit("max", "79.95"), "artifactMetadata.coverage", true},
+		{"decimal128 value", ruleLimit("min", 80.5), "artifactMetadata.decimalCoverage", true},
+		{"int32 equal to float", ruleLimit("equal", float64(0)), "artifactMetadata.criticals", true},
+		{"int64 compared exactly", ruleLimit("max", int64(9007199254740992)), "artifactMetadata.downloads", false},
+		{"string value against min", ruleLimit("min", 1), "artifactMetadata.severity", false},
+		{"string limit against min", ruleLimit("min", "lots"), "artifactMetadata.coverage", false},
+		{"boolean equal passes", ruleLimit("equal", true), "artifactMetadata.signed", true},
+		{"boolean equal fails", ruleLimit("equal", false), "artifactMetadata.signed", false},
+		{"boolean against string", ruleLimit("equal", "true"), "artifactMetadata.signed", false},
+		{"boolean against min", ruleLimit("min", 1), "artifactMetadata.signed", false},
+		{"string against number", ruleLimit("equal", 1), "artifactMetadata.severity", false},
+		{"string equal passes", ruleLimit("equal", "high"), "artifactMetadata.severity", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.limit.Evaluate(artifact, testCase.key)
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+	// Type mismatches are reported explicitly rather than as a failed comparison
+	violation := ruleLimit("equal", 1).Evaluate(artifact, "artifactMetadata.severity")
+	if assert.NotNil(t, violation) {
+		assert.Contains(t, violation.Error(), "type mismatch")
+	}
+
+}
+
+func TestRuleLimitVersions(t *testing.T) {
+
+	artifact := artifacts.Artifact{
+		Name: "Versioned Artifact",
+		ArtifactMetadata: map[string]interface{}{
+			"toolchain": map[string]interface{}{
+				"go": "1.21.4",
+			},
+			"baseImage": "v3.18.2",
+			"build":     "nightly",
+			"release":   float64(2),
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		limit  validation.RuleLimit
+		key    string
+		passes bool
+	}{
+		{"semverMin passes", ruleLimit("semverMin", "1.21.4"), "artifactMetadata.toolchain.go", true},
+		{"semverMin fails", ruleLimit("semverMin", "1.21.5"), "artifactMetadata.toolchain.go", false},
+		{"semverMin is not a string compare", ruleLimit("semverMin", "1.9.0"), "artifactMetadata.toolchain.go", true},
+		{"semverMax passes", ruleLimit("semverMax", "2.0.0"), "artifactMetadata.toolchain.go", true},
+		{"semverMax fails", ruleLimit("semverMax", "1.21.3"), "artifactMetadata.toolchain.go", false},
+		{"semverRange passes", ruleLimit("semverRange", ">=1.20.0 <2.0.0"), "artifactMetadata.toolchain.go", true},
+		{"semverRange fails", ruleLimit("semverRange", ">=1.22.0 <2.0.0"), "artifactMetadata.toolchain.go", false},
+		{"v prefixed version", ruleLimit("semverRange", "^3.18"), "artifactMetadata.baseImage", true},
+		{"invalid artifact version", ruleLimit("semverMin", "1.0.0"), "artifactMetadata.build", false},
+		{"numeric artifact version", ruleLimit("semverMin", "1.0.0"), "artifactMetadata.release", false},
+		{"invalid range", ruleLimit("semverRange", ">>1.0"), "artifactMetadata.toolchain.go", false},
+		{"numeric limit", ruleLimit("semverMin", 1), "artifactMetadata.toolchain.go", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.limit.Evaluate(artifact, testCase.key)
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+}
+
+func TestRuleLimitTimes(t *testing.T) {
+
+	database.SetupDatabase()
+
+	// Store the artifact so it picks up the server recorded timestamps
+	artifactId := primitive.NewObjectID()
+	rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifacts.Artifact{
+		ID:   artifactId,
+		Name: "Timed Artifact",
+		ArtifactMetadata: map[string]interface{}{
+			"builtAt":    time.Now().Add(-10 * 24 * time.Hour).UTC().Format(time.RFC3339),
+			"lastScan":   time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+			"releasedOn": "2023-06-01T12:00:00Z",
+			"notATime":   "yesterday",
+		},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var artifact artifacts.Artifact
+	if err := json.Unmarshal(rr.Body.Bytes(), &artifact); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, artifact.CreatedAt)
+	assert.NotNil(t, artifact.UpdatedAt)
+
+	testCases := []struct {
+		name   string
+		limit  validation.RuleLimit
+		key    string
+		passes bool
+	}{
+		{"newerThan passes", ruleLimit("newerThan", "30d"), "artifactMetadata.builtAt", true},
+		{"newerThan fails", ruleLimit("newerThan", "1w"), "artifactMetadata.builtAt", false},
+		{"newerThan hours", ruleLimit("newerThan", "3h"), "artifactMetadata.lastScan", true},
+		{"newerThan mixed units", ruleLimit("newerThan", "1d12h"), "artifactMetadata.lastScan", true},
+		{"olderThan passes", ruleLimit("olderThan", "2d"), "artifactMetadata.builtAt", true},
+		{"olderThan fails", ruleLimit("olderThan", "1d"), "artifactMetadata.lastScan", false},
+		{"before passes", ruleLimit("before", "2024-01-01T00:00:00Z"), "artifactMetadata.releasedOn", true},
+		{"before fails", ruleLimit("before", "2023-01-01T00:00:00Z"), "artifactMetadata.releasedOn", false},
+		{"after passes", ruleLimit("after", "2023-05-31T23:59:59+01:00"), "artifactMetadata.releasedOn", true},
+		{"after fails", ruleLimit("after", "2023-06-01T12:00:00Z"), "artifactMetadata.releasedOn", false},
+		{"server createdAt", ruleLimit("newerThan", "1h"), "createdAt", true},
+		{"server updatedAt", ruleLimit("olderThan", "1h"), "updatedAt", false},
+		{"invalid artifact timestamp", ruleLimit("newerThan", "30d"), "artifactMetadata.notATime", false},
+		{"invalid duration", ruleLimit("newerThan", "a month"), "artifactMetadata.builtAt", false},
+		{"invalid limit timestamp", ruleLimit("before", "2024-01-01"), "artifactMetadata.releasedOn", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.limit.Evaluate(artifact, testCase.key)
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+}
+
+func TestRuleLimitCollections(t *testing.T) {
+
+	database.SetupDatabase()
+
+	artifact := artifacts.Artifact{
+		ID:   primitive.NewObjectID(),
+		Name: "Scanned Artifact",
+		ArtifactMetadata: map[string]interface{}{
+			"vulns": []interface{}{
+				map[string]interface{}{"id": "CVE-2023-0001", "severity": "HIGH", "score": 7.5},
+				map[string]interface{}{"id": "CVE-2023-0002", "severity": "MEDIUM", "score": 5.1},
+				map[string]interface{}{"id": "CVE-2023-0003", "severity": "HIGH", "score": 8.2},
+				map[string]interface{}{"id": "CVE-2023-0004"},
+			},
+			"images": []interface{}{"registry.local/app:1.2.0", "registry.local/sidecar:0.4.1"},
+			"suites": map[string]interface{}{
+				"results": []interface{}{
+					map[string]interface{}{"name": "unit", "passed": true},
+					map[string]interface{}{"name": "integration", "passed": true},
+				},
+			},
+			"empty": []interface{}{},
+		},
+	}
+
+	// Evaluate against the stored copy too, which comes back with BSON types
+	rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	stored, err := artifacts.GetRepository().Get(context.Background(), artifact.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		limit  validation.RuleLimit
+		key    string
+		passes bool
+	}{
+		{"index", ruleLimit("equal", "MEDIUM"), "artifactMetadata.vulns[1].severity", true},
+		{"index mismatch", ruleLimit("equal", "HIGH"), "artifactMetadata.vulns[1].severity", false},
+		{"index out of range", ruleLimit("absent", nil), "artifactMetadata.vulns[10].severity", true},
+		{"index missing field", ruleLimit("absent", nil), "artifactMetadata.vulns[3].severity", true},
+		{"nested index", ruleLimit("matches", "^integration$"), "artifactMetadata.suites.results[1].name", true},
+		{"invalid key", ruleLimit("exists", nil), "artifactMetadata.vulns[x].severity", false},
+		{"none passes", ruleLimit("none", map[string]interface{}{"type": "equal", "value": "CRITICAL"}), "artifactMetadata.vulns[*].severity", true},
+		{"none fails", ruleLimit("none", map[string]interface{}{"type": "equal", "value": "HIGH"}), "artifactMetadata.vulns[*].severity", false},
+		{"none on empty list", ruleLimit("none", map[string]interface{}{"type": "equal", "value": "HIGH"}), "artifactMetadata.empty", true},
+		{"any passes", ruleLimit("any", map[string]interface{}{"type": "min", "value": 8}), "artifactMetadata.vulns[*].score", true},
+		{"any fails", ruleLimit("any", map[string]interface{}{"type": "min", "value": 9}), "artifactMetadata.vulns[*].score", false},
+		{"all passes", ruleLimit("all", map[string]interface{}{"type": "equal", "value": true}), "artifactMetadata.suites.results[*].passed", true},
+		{"all fails", ruleLimit("all", map[string]interface{}{"type": "matches", "value": "^registry.local/app:"}), "artifactMetadata.images", false},
+		{"all with list limit", ruleLimit("all", map[string]interface{}{"type": "in", "value": []interface{}{"HIGH", "MEDIUM"}}), "artifactMetadata.vulns[*].severity", true},
+		{"count within max", ruleLimit("count", map[string]interface{}{"where": map[string]interface{}{"type": "equal", "value": "HIGH"}, "max": 3}), "artifactMetadata.vulns[*].severity", true},
+		{"count over max", ruleLimit("count", map[string]interface{}{"where": map[string]interface{}{"type": "equal", "value": "HIGH"}, "max": 1}), "artifactMetadata.vulns[*].severity", false},
+		{"count under min", ruleLimit("count", map[string]interface{}{"min": 3}), "artifactMetadata.images", false},
+		{"count exact", ruleLimit("count", 4), "artifactMetadata.vulns", true},
+		{"count skips missing fields", ruleLimit("count", 3), "artifactMetadata.vulns[*].severity", true},
+		{"count of a scalar", ruleLimit("count", 1), "artifactMetadata.vulns[0].id", false},
+		{"count of a missing key", ruleLimit("count", 0), "artifactMetadata.findings[*].severity", false},
+		{"nested limit read back from MongoDB", ruleLimit("none", primitive.D{{Key: "type", Value: "equal"}, {Key: "value", Value: "CRITICAL"}}), "artifactMetadata.vulns[*].severity", true},
+		{"invalid nested limit", ruleLimit("none", "CRITICAL"), "artifactMetadata.vulns[*].severity", false},
+		{"invalid count", ruleLimit("count", "lots"), "artifactMetadata.vulns", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, candidate := range []artifacts.Artifact{artifact, *stored} {
+				violation := testCase.limit.Evaluate(candidate, testCase.key)
+				if testCase.passes {
+					assert.Nil(t, violation)
+				} else {
+					assert.NotNil(t, violation)
+				}
+			}
+		})
+	}
+
+}
+
+func TestCompositeRules(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "composite-" + generateRandomID(6)
+
+	// --------------------------------------------------------------------
+	// Either coverage >= 80 or the artifact is documentation, and never a snapshot
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name": "covered-or-docs",
+		"condition": map[string]interface{}{
+			"allOf": []interface{}{
+				map[string]interface{}{
+					"anyOf": []interface{}{
+						map[string]interface{}{"key": "artifactMetadata.coverage", "limits": []interface{}{map[string]interface{}{"type": "min", "value": 80}}},
+						map[string]interface{}{"key": "artifactType", "limits": []interface{}{map[string]interface{}{"type": "equal", "value": "docs"}}},
+					},
+				},
+				map[string]interface{}{
+					"not": map[string]interface{}{"key": "name", "limits": []interface{}{map[string]interface{}{"type": "matches", "value": "SNAPSHOT$"}}},
+				},
+			},
+		},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	validate := func(artifact artifacts.Artifact) *validation.ValidationResult {
+		result, err := validation.EvaluateArtifact(context.Background(), &artifact, environment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := validate(artifacts.Artifact{Name: "service", ArtifactMetadata: map[string]interface{}{"coverage": 85}})
+	assert.True(t, result.PassesValidation)
+
+	result = validate(artifacts.Artifact{Name: "handbook", ArtifactType: "docs"})
+	assert.True(t, result.PassesValidation)
+
+	result = validate(artifacts.Artifact{Name: "service", ArtifactMetadata: map[string]interface{}{"coverage": 60}})
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, rule.ID.Hex())
+
+	result = validate(artifacts.Artifact{Name: "service-SNAPSHOT", ArtifactMetadata: map[string]interface{}{"coverage": 95}})
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations[rule.ID.Hex()], "not:")
+
+	// --------------------------------------------------------------------
+	// Conditions evaluated directly
+
+	artifact := artifacts.Artifact{
+		ArtifactType:     "library",
+		ArtifactMetadata: map[string]interface{}{"coverage": 72, "signed": true},
+	}
+
+	leaf := func(key string, limits ...validation.RuleLimit) validation.Condition {
+		return validation.Condition{Key: key, Limits: limits}
+	}
+
+	testCases := []struct {
+		name      string
+		condition validation.Condition
+		passes    bool
+	}{
+		{"limits are combined with and", leaf("artifactMetadata.coverage", ruleLimit("min", 70), ruleLimit("max", 71)), false},
+		{"allOf passes", validation.Condition{AllOf: []validation.Condition{leaf("artifactMetadata.coverage", ruleLimit("min", 70)), leaf("artifactMetadata.signed", ruleLimit("equal", true))}}, true},
+		{"allOf fails", validation.Condition{AllOf: []validation.Condition{leaf("artifactMetadata.coverage", ruleLimit("min", 80)), leaf("artifactMetadata.signed", ruleLimit("equal", true))}}, false},
+		{"anyOf passes", validation.Condition{AnyOf: []validation.Condition{leaf("artifactMetadata.coverage", ruleLimit("min", 80)), leaf("artifactType", ruleLimit("equal", "library"))}}, true},
+		{"anyOf fails", validation.Condition{AnyOf: []validation.Condition{leaf("artifactMetadata.coverage", ruleLimit("min", 80)), leaf("artifactType", ruleLimit("equal", "docs"))}}, false},
+		{"not passes", validation.Condition{Not: &validation.Condition{Key: "artifactType", Limits: []validation.RuleLimit{ruleLimit("equal", "docs")}}}, true},
+		{"not fails", validation.Condition{Not: &validation.Condition{Key: "artifactType", Limits: []validation.RuleLimit{ruleLimit("equal", "library")}}}, false},
+		{"children inherit the key", validation.Condition{Key: "artifactMetadata.coverage", AnyOf: []validation.Condition{{Limits: []validation.RuleLimit{ruleLimit("min", 90)}}, {Limits: []validation.RuleLimit{ruleLimit("equal", 72)}}}}, true},
+		{"empty condition", validation.Condition{}, false},
+		{"empty child", validation.Condition{AllOf: []validation.Condition{{}}}, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.condition.Evaluate(artifact, "")
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+}
+
+func TestExpressionRules(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "expression-" + generateRandomID(6)
+
+	// --------------------------------------------------------------------
+	// Invalid expressions are rejected when the rule is created
+
+	for _, expression := range []string{
+		`artifactMetadata.coverage >=`, // syntax error
+		`nmae == "service"`,            // undeclared variable
+		`name`,                         // not a bool
+		`artifactType + 1 > 2`,         // type error
+	} {
+		rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+			"name":       "invalid expression",
+			"expression": expression,
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, expression)
+	}
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name": "invalid nested expression",
+		"condition": map[string]interface{}{
+			"anyOf": []interface{}{map[string]interface{}{"expression": `artifactFamily ==`}},
+		},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	// --------------------------------------------------------------------
+	// A valid expression rule gates the environment
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "covered-or-docs",
+		"expression": `artifactType == "docs" || (artifactMetadata.coverage >= 80 && artifactMetadata.vulns.all(v, v.severity != "CRITICAL"))`,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Validate through the stored artifact, so the expression sees values read back from the database
+	validate := func(artifact artifacts.Artifact) validation.ValidationResult {
+		artifact.ID = primitive.NewObjectID()
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  artifact.ID.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := validate(artifacts.Artifact{ArtifactMetadata: map[string]interface{}{
+		"coverage": 85,
+		"vulns":    []interface{}{map[string]interface{}{"severity": "HIGH"}},
+	}})
+	assert.True(t, result.PassesValidation)
+
+	result = validate(artifacts.Artifact{ArtifactMetadata: map[string]interface{}{
+		"coverage": 85,
+		"vulns":    []interface{}{map[string]interface{}{"severity": "CRITICAL"}},
+	}})
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, rule.ID.Hex())
+
+	result = validate(artifacts.Artifact{ArtifactType: "docs"})
+	assert.True(t, result.PassesValidation)
+
+	// Missing metadata fails the rule rather than erroring the request
+	result = validate(artifacts.Artifact{ArtifactType: "service"})
+	assert.False(t, result.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Expressions evaluated directly
+
+	now := time.Now().UTC()
+	artifact := artifacts.Artifact{
+		Name:             "payments-api",
+		ArtifactFamily:   "payments",
+		CreatedAt:        &now,
+		ArtifactMetadata: map[string]interface{}{"signed": true, "replicas": int32(3), "score": 7.5},
+	}
+
+	testCases := []struct {
+		name       string
+		expression validation.Expression
+		passes     bool
+	}{
+		{"string functions", `name.startsWith("payments") && artifactFamily in ["payments", "billing"]`, true},
+		{"dyn bool", `artifactMetadata.signed`, true},
+		{"mixed numbers", `artifactMetadata.replicas >= 2 && artifactMetadata.score < 8`, true},
+		{"false", `artifactMetadata.replicas > 3`, false},
+		{"has", `!has(artifactMetadata.deprecated)`, true},
+		{"timestamps", `createdAt > timestamp("2020-01-01T00:00:00Z")`, true},
+		{"dyn non bool", `artifactMetadata.score`, false},
+		{"invalid", `name ==`, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			violation := testCase.expression.Evaluate(artifact, "")
+			if testCase.passes {
+				assert.Nil(t, violation)
+			} else {
+				assert.NotNil(t, violation)
+			}
+		})
+	}
+
+}
+
+func TestRegoRules(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "rego-" + generateRandomID(6)
+
+	// --------------------------------------------------------------------
+	// Invalid modules are rejected when the rule is created
+
+	for _, module := range []string{
+		"package artifactflow.broken\n\ndeny[msg] {",                                                                                     // parse error
+		"package artifactflow.nodeny\n\nallow { true }",                                                                                  // no deny rule
+		"package artifactflow.unsafe\n\ndeny[msg] { input.artifact.name == x }",                                                          // unsafe variable
+		"package artifactflow.network\n\ndeny[msg] { http.send({\"method\": \"get\", \"url\": \"http://localhost\"}); msg := \"sent\" }", // network access
+		"package artifactflow.lookup\n\ndeny[msg] { net.lookup_ip_addr(\"localhost\"); msg := \"resolved\" }",                            // network access
+		"package artifactflow.runtime\n\ndeny[msg] { msg := opa.runtime().env.HOME }",                                                    // server environment
+	} {
+		rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+			"name": "invalid policy",
+			"rego": module,
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, module)
+	}
+
+	// --------------------------------------------------------------------
+	// A Rego rule & a limit rule mapped to the same environment
+
+	policyRule := createMappedRule(t, map[string]interface{}{
+		"name": "security-policy",
+		"rego": `package artifactflow.security
+
+deny[msg] {
+	vuln := input.artifact.artifactMetadata.vulns[_]
+	vuln.severity == "CRITICAL"
+	msg := sprintf("%s is critical", [vuln.id])
+}
+
+deny[msg] {
+	startswith(input.environment, "rego-")
+	not input.artifact.artifactMetadata.signed
+	msg := sprintf("unsigned artifacts cannot enter %s", [input.environment])
+}`,
+	}, map[string]interface{}{"environments": map[string]interface{}{environment: true}})
+	coverageRule := createMappedRule(t, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	}, map[string]interface{}{"environments": map[string]interface{}{environment: true}})
+
+	validate := func(artifact artifacts.Artifact) validation.ValidationResult {
+		artifact.ID = primitive.NewObjectID()
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  artifact.ID.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := validate(artifacts.Artifact{ArtifactMetadata: map[string]interface{}{
+		"signed":   true,
+		"coverage": 90,
+		"vulns":    []interface{}{map[string]interface{}{"id": "CVE-2023-0001", "severity": "LOW"}},
+	}})
+	assert.True(t, result.PassesValidation)
+	assert.Empty(t, result.Violations)
+
+	result = validate(artifacts.Artifact{ArtifactMetadata: map[string]interface{}{
+		"coverage": 70,
+		"vulns":    []interface{}{map[string]interface{}{"id": "CVE-2023-0002", "severity": "CRITICAL"}},
+	}})
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, coverageRule)
+	assert.Contains(t, result.Violations[policyRule], "CVE-2023-0002 is critical")
+	assert.Contains(t, result.Violations[policyRule], "unsigned artifacts cannot enter "+environment)
+
+}
+
+func TestValidationReport(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "report-" + generateRandomID(6)
+
+	// Two rules on the same key are reported separately
+	minimumRule := createMappedRule(t, map[string]interface{}{
+		"name":       "minimum-coverage",
+		"ruleFamily": "code",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}, map[string]interface{}{"type": "max", "value": 100}},
+	}, map[string]interface{}{"enforced": true, "environments": map[string]interface{}{environment: true}})
+	targetRule := createMappedRule(t, map[string]interface{}{
+		"name":       "target-coverage",
+		"ruleFamily": "code",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 90}},
+	}, map[string]interface{}{"enforced": false, "environments": map[string]interface{}{environment: true}})
+	expressionRule := createMappedRule(t, map[string]interface{}{
+		"name":       "signed",
+		"expression": "artifactMetadata.signed == true",
+	}, map[string]interface{}{"enforced": true, "environments": map[string]interface{}{environment: true}})
+
+	artifact := artifacts.Artifact{ID: primitive.NewObjectID(), ArtifactMetadata: map[string]interface{}{"coverage": 75, "signed": true}}
+	rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+		ArtifactID:  artifact.ID.Hex(),
+		Environment: environment,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result validation.ValidationResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(t, result.PassesValidation)
+	assert.Equal(t, map[string]string{minimumRule: "75 is less than 80"}, result.Violations)
+	assert.Equal(t, map[string]string{targetRule: "75 is less than 90"}, result.Warnings)
+
+	if !assert.Len(t, result.Rules, 3) {
+		return
+	}
+
+	minimum := result.Rules[0]
+	assert.Equal(t, minimumRule, minimum.RuleId)
+	assert.Equal(t, "minimum-coverage", minimum.Name)
+	assert.Equal(t, "code", minimum.RuleFamily)
+	assert.Equal(t, "artifactMetadata.coverage", minimum.RuleKey)
+	assert.True(t, minimum.Enforced)
+	assert.False(t, minimum.Passed)
+	assert.Equal(t, []validation.CheckResult{
+		{Key: "artifactMetadata.coverage", LimitType: "min", Expected: float64(80), Actual: float64(75), Passed: false, Message: "75 is less than 80"},
+		{Key: "artifactMetadata.coverage", LimitType: "max", Expected: float64(100), Actual: float64(75), Passed: true},
+	}, minimum.Checks)
+
+	target := result.Rules[1]
+	assert.Equal(t, targetRule, target.RuleId)
+	assert.False(t, target.Enforced)
+	assert.False(t, target.Passed)
+
+	expression := result.Rules[2]
+	assert.Equal(t, expressionRule, expression.RuleId)
+	assert.True(t, expression.Passed)
+	assert.Equal(t, []validation.CheckResult{
+		{LimitType: "expression", Expected: "artifactMetadata.signed == true", Passed: true},
+	}, expression.Checks)
+
+}
+
+func TestEvaluateInlineArtifact(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "dryrun-" + generateRandomID(6)
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	countArtifacts := func() int {
+		stored, err := artifacts.GetRepository().List(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(stored)
+	}
+	before := countArtifacts()
+
+	evaluate := func(body interface{}) (*httptest.ResponseRecorder, validation.ValidationResult) {
+		rr := callHandler(t, validation.EvaluateInlineArtifact, "POST", "/validation/evaluate", nil, body)
+
+		var result validation.ValidationResult
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, result
+	}
+
+	rr, result := evaluate(map[string]interface{}{
+		"artifact":    map[string]interface{}{"name": "build-123", "artifactMetadata": map[string]interface{}{"coverage": 85}},
+		"environment": environment,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, result.PassesValidation)
+	assert.Equal(t, "", result.ArtifactId)
+	assert.Len(t, result.Rules, 1)
+
+	rr, result = evaluate(map[string]interface{}{
+		"artifact":    map[string]interface{}{"name": "build-124", "artifactMetadata": map[string]interface{}{"coverage": 60}},
+		"environment": environment,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, rule.ID.Hex())
+
+	// Nothing was persisted
+	assert.Equal(t, before, countArtifacts())
+
+	rr, _ = evaluate(map[string]interface{}{"environment": environment})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr, _ = evaluate(map[string]interface{}{"artifact": map[string]interface{}{"name": "build-125"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+}
+
+func TestBatchValidation(t *testing.T) {
+
+	database.SetupDatabase()
+
+	release := "release-" + generateRandomID(6)
+	staging := "staging-" + generateRandomID(6)
+	production := "production-" + generateRandomID(6)
+
+	// Coverage applies to both environments, signing only to production
+	createMappedRule(t, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	}, map[string]interface{}{"environments": map[string]interface{}{staging: true, production: true}})
+	createMappedRule(t, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.signed",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "equal", "value": true}},
+	}, map[string]interface{}{"environments": map[string]interface{}{production: true}})
+
+	createArtifact := func(metadata map[string]interface{}) string {
+		metadata["release"] = release
+		artifact := artifacts.Artifact{ID: primitive.NewObjectID(), ArtifactMetadata: metadata}
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return artifact.ID.Hex()
+	}
+
+	ready := createArtifact(map[string]interface{}{"coverage": 90, "signed": true})
+	unsigned := createArtifact(map[string]interface{}{"coverage": 85})
+	uncovered := createArtifact(map[string]interface{}{"coverage": 40, "signed": true})
+
+	batch := func(body interface{}) (*httptest.ResponseRecorder, validation.BatchValidationResult) {
+		rr := callHandler(t, validation.ValidateArtifacts, "POST", "/validation/batch", nil, body)
+
+		var result validation.BatchValidationResult
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, result
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: By artifact IDs, including ones that can't be loaded
+
+	missing := primitive.NewObjectID().Hex()
+	rr, result := batch(map[string]interface{}{
+		"artifactIds":  []string{ready, unsigned, uncovered, missing, "not-an-id"},
+		"environments": []string{staging, production},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, result.PassesValidation)
+	assert.Len(t, result.Results, 3)
+	assert.Equal(t, map[string]string{missing: "artifact not found", "not-an-id": "invalid artifact ID"}, result.Errors)
+
+	expected := map[string]map[string]bool{
+		ready:     {staging: true, production: true},
+		unsigned:  {staging: true, production: false},
+		uncovered: {staging: false, production: false},
+	}
+	for artifactID, environments := range expected {
+		for environment, passes := range environments {
+			if assert.Contains(t, result.Results[artifactID], environment) {
+				assert.Equal(t, passes, result.Results[artifactID][environment].PassesValidation, artifactID+" in "+environment)
+				assert.Equal(t, artifactID, result.Results[artifactID][environment].ArtifactId)
+			}
+		}
+	}
+
+	// --------------------------------------------------------------------
+	// Test 2: By search filter
+
+	rr, result = batch(map[string]interface{}{
+		"filter":       database.SearchFilter{SearchKey: "release", SearchValue: release, SearchVerb: "equal"},
+		"environments": []string{staging},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, result.PassesValidation)
+	assert.Len(t, result.Results, 3)
+	assert.True(t, result.Results[unsigned][staging].PassesValidation)
+	assert.False(t, result.Results[uncovered][staging].PassesValidation)
+
+	rr, result = batch(map[string]interface{}{
+		"artifactIds":  []string{ready},
+		"environments": []string{staging, production},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, result.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Test 3: Invalid requests
+
+	rr, _ = batch(map[string]interface{}{"artifactIds": []string{ready}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr, _ = batch(map[string]interface{}{"environments": []string{staging}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr, _ = batch(map[string]interface{}{
+		"artifactIds":  []string{ready},
+		"filter":       database.SearchFilter{SearchKey: "release", SearchValue: release, SearchVerb: "equal"},
+		"environments": []string{staging},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+}
+
+func TestValidationHistory(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "history-" + generateRandomID(6)
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	artifact := artifacts.Artifact{ID: primitive.NewObjectID(), ArtifactMetadata: map[string]interface{}{"coverage": 60}}
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	listHistory := func(handler http.HandlerFunc, vars map[string]string) []validation.ValidationRecord {
+		rr := callHandler(t, handler, "GET", "/validation/results", vars, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var records []validation.ValidationRecord
+		if err := json.Unmarshal(rr.Body.Bytes(), &records); err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: A failing validation is recorded with the rule set as it was
+
+	rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+		ArtifactID:  artifact.ID.Hex(),
+		Environment: environment,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The rule is then relaxed & the artifact validated again, through a batch
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+		"name":       "coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 50}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.ValidateArtifacts, "POST", "/validation/batch", nil, map[string]interface{}{
+		"artifactIds":  []string{artifact.ID.Hex()},
+		"environments": []string{environment},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Dry runs are not recorded
+	rr = callHandler(t, validation.EvaluateInlineArtifact, "POST", "/validation/evaluate", nil, map[string]interface{}{
+		"artifact":    artifact,
+		"environment": environment,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	records := listHistory(validation.GetArtifactValidations, map[string]string{"id": artifact.ID.Hex()})
+	if !assert.Len(t, records, 2) {
+		return
+	}
+
+	first := records[0]
+	assert.Equal(t, artifact.ID.Hex(), first.ArtifactId)
+	assert.Equal(t, environment, first.Environment)
+	assert.False(t, first.PassesValidation)
+	assert.Contains(t, first.Violations, rule.ID.Hex())
+	assert.Equal(t, validation.SourceValidation, first.Source)
+	assert.Equal(t, "anonymous", first.Actor)
+	assert.False(t, first.Timestamp.IsZero())
+	if assert.Len(t, first.RuleSet, 1) {
+		assert.Equal(t, rule.ID, first.RuleSet[0].ID)
+		assert.Equal(t, float64(80), *first.RuleSet[0].RuleLimits[0].Value)
+	}
+
+	second := records[1]
+	assert.True(t, second.PassesValidation)
+	assert.Equal(t, validation.SourceBatch, second.Source)
+	if assert.Len(t, second.RuleSet, 1) {
+		assert.Equal(t, float64(50), *second.RuleSet[0].RuleLimits[0].Value)
+	}
+	assert.False(t, second.Timestamp.Before(first.Timestamp))
+
+	// --------------------------------------------------------------------
+	// Test 2: Listing by environment & fetching a single record
+
+	records = listHistory(validation.GetEnvironmentValidations, map[string]string{"name": environment})
+	assert.Len(t, records, 2)
+
+	rr = callHandler(t, validation.GetValidation, "GET", "/validation/results/"+first.ID.Hex(), map[string]string{"id": first.ID.Hex()}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var record validation.ValidationRecord
+	if err := json.Unmarshal(rr.Body.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, first.ID, record.ID)
+	assert.Equal(t, first.Violations, record.Violations)
+
+	missing := primitive.NewObjectID().Hex()
+	rr = callHandler(t, validation.GetValidation, "GET", "/validation/results/"+missing, map[string]string{"id": missing}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = callHandler(t, validation.GetArtifactValidations, "GET", "/validation/results/artifacts/invalid", map[string]string{"id": "invalid"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+}
+
+func TestValidationWaivers(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "waivers-" + generateRandomID(6)
+	family := "family-" + generateRandomID(6)
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "no critical vulnerabilities",
+		"ruleKey":    "artifactMetadata.criticalVulnerabilities",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": 0}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	createArtifact := func(artifactFamily string) artifacts.Artifact {
+		artifact := artifacts.Artifact{
+			ID:               primitive.NewObjectID(),
+			ArtifactFamily:   artifactFamily,
+			ArtifactMetadata: map[string]interface{}{"criticalVulnerabilities": 2},
+		}
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return artifact
+	}
+
+	createWaiver := func(waiver map[string]interface{}) *httptest.ResponseRecorder {
+		body := map[string]interface{}{
+			"ruleId":        rule.ID.Hex(),
+			"environments":  []string{environment},
+			"expiresAt":     time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+			"justification": "Vulnerable code path is not reachable, fix scheduled",
+			"approver":      "security-team",
+		}
+		for key, value := range waiver {
+			body[key] = value
+		}
+		return callHandler(t, validation.CreateWaiver, "POST", "/validation/waivers", nil, body)
+	}
+
+	validate := func(artifact artifacts.Artifact) validation.ValidationResult {
+		rr := callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  artifact.ID.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: Without a waiver the artifact fails validation
+
+	waived := createArtifact(family)
+	result := validate(waived)
+	assert.False(t, result.PassesValidation)
+	assert.Contains(t, result.Violations, rule.ID.Hex())
+
+	// --------------------------------------------------------------------
+	// Test 2: A waiver for the artifact moves the failure out of the violations
+
+	rr = createWaiver(map[string]interface{}{"artifactId": waived.ID.Hex()})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var waiver validation.Waiver
+	if err := json.Unmarshal(rr.Body.Bytes(), &waiver); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "anonymous", waiver.CreatedBy)
+
+	result = validate(waived)
+	assert.True(t, result.PassesValidation)
+	assert.Empty(t, result.Violations)
+	assert.Contains(t, result.Waived, rule.ID.Hex())
+	if assert.Len(t, result.Rules, 1) {
+		assert.False(t, result.Rules[0].Passed)
+		assert.True(t, result.Rules[0].Waived)
+		assert.Equal(t, waiver.ID.Hex(), result.Rules[0].WaiverId)
+	}
+
+	// Other artifacts in the family are still failing
+	sibling := createArtifact(family)
+	result = validate(sibling)
+	assert.False(t, result.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Test 3: A waiver for the family covers every artifact in it, but not in other environments
+
+	rr = createWaiver(map[string]interface{}{"artifactFamily": family})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	result = validate(sibling)
+	assert.True(t, result.PassesValidation)
+	assert.Contains(t, result.Waived, rule.ID.Hex())
+
+	other := createArtifact("family-" + generateRandomID(6))
+	result = validate(other)
+	assert.False(t, result.PassesValidation)
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment + "-prod": true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+		ArtifactID:  sibling.ID.Hex(),
+		Environment: environment + "-prod",
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, result.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Test 4: Expired waivers stop applying
+
+	expiring := createArtifact("family-" + generateRandomID(6))
+	_, err := validation.GetWaiverRepository().Create(context.TODO(), validation.Waiver{
+		RuleId:        rule.ID,
+		ArtifactId:    expiring.ID.Hex(),
+		Environments:  []string{environment},
+		ExpiresAt:     time.Now().Add(-time.Minute).UTC(),
+		Justification: "Temporary exemption",
+		Approver:      "security-team",
+	})
+	assert.NoError(t, err)
+
+	result = validate(expiring)
+	assert.False(t, result.PassesValidation)
+	assert.Empty(t, result.Waived)
+
+	// --------------------------------------------------------------------
+	// Test 5: Invalid waivers are rejected
+
+	rr = createWaiver(map[string]interface{}{})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = createWaiver(map[string]interface{}{"artifactId": waived.ID.Hex(), "artifactFamily": family})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = createWaiver(map[string]interface{}{"artifactFamily": family, "expiresAt": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = createWaiver(map[string]interface{}{"artifactFamily": family, "approver": ""})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = createWaiver(map[string]interface{}{"artifactFamily": family, "ruleId": primitive.NewObjectID().Hex()})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 6: Revoking a waiver
+
+	rr = callHandler(t, validation.DeleteWaiver, "DELETE", "/validation/waivers/"+waiver.ID.Hex(), map[string]string{"id": waiver.ID.Hex()}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.GetWaiver, "GET", "/validation/waivers/"+waiver.ID.Hex(), map[string]string{"id": waiver.ID.Hex()}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+}
+
+func TestRuleSelectors(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "selectors-" + generateRandomID(6)
+
+	// Coverage only applies to jars, the mapping narrows it further to backend services
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+		"selector":   map[string]interface{}{"artifactTypes": []string{"jar"}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var coverage validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &coverage); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       coverage.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+		"selector":     map[string]interface{}{"labels": map[string]string{"tier": "backend"}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Every artifact needs a name
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "named",
+		"ruleKey":    "name",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "set"}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var named validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &named); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       named.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	tests := []struct {
+		name     string
+		artifact artifacts.Artifact
+		rules    []string
+		passes   bool
+	}{
+		{
+			name: "backend jar is checked for coverage",
+			artifact: artifacts.Artifact{Name: "api", ArtifactType: "jar", ArtifactMetadata: map[string]interface{}{
+				"coverage": 60,
+				"labels":   map[string]interface{}{"tier": "backend"},
+			}},
+			rules:  []string{coverage.ID.Hex(), named.ID.Hex()},
+			passes: false,
+		},
+		{
+			name: "helm chart skips coverage",
+			artifact: artifacts.Artifact{Name: "chart", ArtifactType: "helm", ArtifactMetadata: map[string]interface{}{
+				"labels": map[string]interface{}{"tier": "backend"},
+			}},
+			rules:  []string{named.ID.Hex()},
+			passes: true,
+		},
+		{
+			name: "frontend jar skips coverage",
+			artifact: artifacts.Artifact{Name: "ui", ArtifactType: "jar", ArtifactMetadata: map[string]interface{}{
+				"coverage": 60,
+				"labels":   map[string]interface{}{"tier": "frontend"},
+			}},
+			rules:  []string{named.ID.Hex()},
+			passes: true,
+		},
+		{
+			name:     "unlabelled jar skips coverage",
+			artifact: artifacts.Artifact{Name: "worker", ArtifactType: "jar", ArtifactMetadata: map[string]interface{}{"coverage": 60}},
+			rules:    []string{named.ID.Hex()},
+			passes:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := callHandler(t, validation.EvaluateInlineArtifact, "POST", "/validation/evaluate", nil, map[string]interface{}{
+				"artifact":    test.artifact,
+				"environment": environment,
+			})
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			var result validation.ValidationResult
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+
+			var evaluated []string
+			for _, rule := range result.Rules {
+				evaluated = append(evaluated, rule.RuleId)
+			}
+			assert.Equal(t, test.rules, evaluated)
+			assert.Equal(t, test.passes, result.PassesValidation)
+		})
+	}
+
+	// Batches apply the selectors per artifact
+	chart := artifacts.Artifact{ID: primitive.NewObjectID(), Name: "chart", ArtifactType: "helm", ArtifactMetadata: map[string]interface{}{"coverage": 0}}
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, chart)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.ValidateArtifacts, "POST", "/validation/batch", nil, map[string]interface{}{
+		"artifactIds":  []string{chart.ID.Hex()},
+		"environments": []string{environment},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var batch validation.BatchValidationResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &batch); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, batch.PassesValidation)
+	if result := batch.Results[chart.ID.Hex()][environment]; assert.NotNil(t, result) {
+		assert.Len(t, result.Rules, 1)
+	}
+
+}
+
+func TestRuleDefinitionValidation(t *testing.T) {
+
+	database.SetupDatabase()
+
+	rejected := func(rule map[string]interface{}) map[string]string {
+		rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, rule)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+		var response validation.RuleValidationError
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "Invalid rule", response.Error)
+
+		fields := make(map[string]string)
+		for _, fieldError := range response.Fields {
+			fields[fieldError.Field] = fieldError.Message
+		}
+		return fields
+	}
+
+	tests := []struct {
+		name   string
+		rule   map[string]interface{}
+		fields []string
+	}{
+		{
+			name: "unknown limit type",
+			rule: map[string]interface{}{
+				"ruleKey":    "artifactMetadata.coverage",
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "atLeast", "value": 80}},
+			},
+			fields: []string{"ruleLimits[0].type"},
+		},
+		{
+			name: "missing value",
+			rule: map[string]interface{}{
+				"ruleKey":    "artifactMetadata.coverage",
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "set"}, map[string]interface{}{"type": "min"}},
+			},
+			fields: []string{"ruleLimits[1].value"},
+		},
+		{
+			name: "min with a string",
+			rule: map[string]interface{}{
+				"ruleKey":    "artifactMetadata.coverage",
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": "eighty"}},
+			},
+			fields: []string{"ruleLimits[0].value"},
+		},
+		{
+			name: "every problem is reported",
+			rule: map[string]interface{}{
+				"ruleKey": "artifactMetadata.vulns[x].severity",
+				"ruleLimits": []interface{}{
+					map[string]interface{}{"type": "in", "value": "MIT"},
+					map[string]interface{}{"type": "matches", "value": "("},
+					map[string]interface{}{"type": "semverMin", "value": "latest"},
+					map[string]interface{}{"type": "olderThan", "value": "a while"},
+				},
+			},
+			fields: []string{"ruleKey", "ruleLimits[0].value", "ruleLimits[1].value", "ruleLimits[2].value", "ruleLimits[3].value"},
+		},
+		{
+			name: "limits without a key",
+			rule: map[string]interface{}{
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": 0}},
+			},
+			fields: []string{"ruleKey"},
+		},
+		{
+			name: "empty key segment",
+			rule: map[string]interface{}{
+				"ruleKey":    "artifactMetadata..coverage",
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": 0}},
+			},
+			fields: []string{"ruleKey"},
+		},
+		{
+			name: "nested limits",
+			rule: map[string]interface{}{
+				"ruleKey": "artifactMetadata.vulns[*].severity",
+				"ruleLimits": []interface{}{
+					map[string]interface{}{"type": "any", "value": map[string]interface{}{"type": "equal"}},
+					map[string]interface{}{"type": "count", "value": map[string]interface{}{"max": "three"}},
+				},
+			},
+			fields: []string{"ruleLimits[0].value.value", "ruleLimits[1].value.max"},
+		},
+		{
+			name: "condition",
+			rule: map[string]interface{}{
+				"condition": map[string]interface{}{
+					"anyOf": []interface{}{
+						map[string]interface{}{"limits": []interface{}{map[string]interface{}{"type": "min", "value": 80}}},
+						map[string]interface{}{},
+						map[string]interface{}{"expression": "artifactFamily =="},
+					},
+				},
+			},
+			fields: []string{"condition.anyOf[0].key", "condition.anyOf[1]", "condition.anyOf[2].expression"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := rejected(test.rule)
+
+			var got []string
+			for field := range fields {
+				got = append(got, field)
+			}
+			assert.ElementsMatch(t, test.fields, got)
+		})
+	}
+
+	// --------------------------------------------------------------------
+	// Updates are checked too, against the stored rule key when none is given
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": "a hundred"}},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": 100}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// A body that isn't JSON is rejected rather than wiping the rule
+	req, err := http.NewRequest("PUT", "/validation/rules/"+rule.ID.Hex(), bytes.NewBufferString("not json{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	validation.UpdateRule(rr, mux.SetURLVars(req, map[string]string{"id": rule.ID.Hex()}))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	stored, err := validation.GetRuleRepository().Get(context.Background(), rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, stored.Version)
+	assert.Len(t, stored.RuleLimits, 1)
+
+	// Decimal strings are numbers, as they are when the rule is evaluated
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": "99.5"}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+}
+
+func TestRuleTestHarness(t *testing.T) {
+
+	database.SetupDatabase()
+
+	rule := map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+		"selector":   map[string]interface{}{"artifactTypes": []string{"jar"}},
+	}
+
+	stored := artifacts.Artifact{ID: primitive.NewObjectID(), Name: "stored", ArtifactType: "jar", ArtifactMetadata: map[string]interface{}{"coverage": 85}}
+	rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, stored)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	missing := primitive.NewObjectID().Hex()
+	samples := []artifacts.Artifact{
+		{Name: "uncovered", ArtifactType: "jar", ArtifactMetadata: map[string]interface{}{"coverage": 60}},
+		{Name: "chart", ArtifactType: "helm"},
+	}
+
+	decode := func(rr *httptest.ResponseRecorder) validation.RuleTestResult {
+		var result validation.RuleTestResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: An unsaved rule against samples & stored artifacts
+
+	rr = callHandler(t, validation.TestUnsavedRule, "POST", "/validation/rules/test", nil, map[string]interface{}{
+		"rule":        rule,
+		"artifacts":   samples,
+		"artifactIds": []string{stored.ID.Hex(), missing},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	result := decode(rr)
+	assert.False(t, result.Passed)
+	assert.Empty(t, result.RuleId)
+	assert.Contains(t, result.Errors, missing)
+	if assert.Len(t, result.Results, 3) {
+		uncovered, chart, storedResult := result.Results[0], result.Results[1], result.Results[2]
+
+		assert.Equal(t, "uncovered", uncovered.Name)
+		assert.Empty(t, uncovered.ArtifactId)
+		assert.True(t, uncovered.Applies)
+		assert.False(t, uncovered.Passed)
+		assert.Equal(t, "60 is less than 80", uncovered.Message)
+		assert.Equal(t, float64(60), uncovered.Values["artifactMetadata.coverage"])
+
+		assert.False(t, chart.Applies)
+		assert.False(t, chart.Passed)
+		assert.NotContains(t, chart.Values, "artifactMetadata.coverage")
+
+		assert.Equal(t, stored.ID.Hex(), storedResult.ArtifactId)
+		assert.True(t, storedResult.Passed)
+		assert.Equal(t, float64(85), storedResult.Values["artifactMetadata.coverage"])
+	}
+
+	// --------------------------------------------------------------------
+	// Test 2: A saved rule
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, rule)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var created validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.TestRule, "POST", "/validation/rules/"+created.ID.Hex()+"/test", map[string]string{"id": created.ID.Hex()}, map[string]interface{}{
+		"artifactIds": []string{stored.ID.Hex()},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	result = decode(rr)
+	assert.True(t, result.Passed)
+	assert.Equal(t, created.ID.Hex(), result.RuleId)
+	assert.Len(t, result.Results, 1)
+
+	// --------------------------------------------------------------------
+	// Test 3: Invalid requests
+
+	unknown := primitive.NewObjectID().Hex()
+	rr = callHandler(t, validation.TestRule, "POST", "/validation/rules/"+unknown+"/test", map[string]string{"id": unknown}, map[string]interface{}{
+		"artifacts": samples,
+	})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = callHandler(t, validation.TestRule, "POST", "/validation/rules/"+created.ID.Hex()+"/test", map[string]string{"id": created.ID.Hex()}, map[string]interface{}{})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.TestUnsavedRule, "POST", "/validation/rules/test", nil, map[string]interface{}{
+		"rule":      map[string]interface{}{"ruleKey": "artifactMetadata.coverage", "ruleLimits": []interface{}{map[string]interface{}{"type": "min"}}},
+		"artifacts": samples,
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+}
+
+func TestPolicySimulation(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "simulation-" + generateRandomID(6)
+	family := "simulation-" + generateRandomID(6)
+	filter := map[string]interface{}{"searchKey": "artifactFamily", "searchValue": family, "searchVerb": "equal"}
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 50}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var mapping validation.ValidationRuleMapping
+	if err := json.Unmarshal(rr.Body.Bytes(), &mapping); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]string)
+	for name, coverage := range map[string]int{"low": 40, "medium": 70, "high": 90} {
+		artifact := artifacts.Artifact{ID: primitive.NewObjectID(), Name: name, ArtifactFamily: family, ArtifactMetadata: map[string]interface{}{"coverage": coverage}}
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		ids[name] = artifact.ID.Hex()
+	}
+
+	simulate := func(req map[string]interface{}) validation.SimulationResult {
+		req["environment"] = environment
+		req["filter"] = filter
+		rr := callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.SimulationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	changed := func(result validation.SimulationResult) []string {
+		var changed []string
+		for _, artifact := range result.Changed {
+			changed = append(changed, artifact.ArtifactId)
+		}
+		return changed
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: Tightening a mapped rule
+
+	result := simulate(map[string]interface{}{
+		"rule": map[string]interface{}{
+			"id":         rule.ID.Hex(),
+			"ruleKey":    "artifactMetadata.coverage",
+			"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+		},
+	})
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, 2, result.PassingBefore)
+	assert.Equal(t, 1, result.PassingAfter)
+	assert.Equal(t, 1, result.NewlyFailing)
+	assert.Equal(t, 0, result.NewlyPassing)
+	assert.Equal(t, []string{ids["medium"]}, changed(result))
+	if assert.Len(t, result.Changed, 1) {
+		assert.True(t, result.Changed[0].PassesBefore)
+		assert.False(t, result.Changed[0].PassesAfter)
+		assert.Equal(t, "70 is less than 80", result.Changed[0].Violations[rule.ID.Hex()])
+	}
+
+	// Nothing is saved
+	rr = callHandler(t, validation.GetRule, "GET", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, nil)
+	var stored validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(50), *stored.RuleLimits[0].Value)
+
+	// --------------------------------------------------------------------
+	// Test 2: A new rule is simulated as mapped & enforced
+
+	result = simulate(map[string]interface{}{
+		"rule": map[string]interface{}{
+			"ruleKey":    "artifactMetadata.coverage",
+			"ruleLimits": []interface{}{map[string]interface{}{"type": "max", "value": 80}},
+		},
+	})
+	assert.Equal(t, 1, result.NewlyFailing)
+	assert.Equal(t, []string{ids["high"]}, changed(result))
+
+	// --------------------------------------------------------------------
+	// Test 3: Mapping changes
+
+	result = simulate(map[string]interface{}{
+		"mapping": map[string]interface{}{
+			"id":           mapping.ID.Hex(),
+			"ruleId":       rule.ID.Hex(),
+			"environments": map[string]interface{}{environment: true},
+			"enforced":     false,
+		},
+	})
+	assert.Equal(t, 1, result.NewlyPassing)
+	assert.Equal(t, []string{ids["low"]}, changed(result))
+
+	result = simulate(map[string]interface{}{
+		"mapping": map[string]interface{}{
+			"id":           mapping.ID.Hex(),
+			"ruleId":       rule.ID.Hex(),
+			"environments": map[string]interface{}{"elsewhere": true},
+		},
+	})
+	assert.Equal(t, 3, result.PassingAfter)
+	assert.Equal(t, []string{ids["low"]}, changed(result))
+
+	// --------------------------------------------------------------------
+	// Test 4: Invalid requests
+
+	rr = callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, map[string]interface{}{"environment": environment})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, map[string]interface{}{
+		"environment": environment,
+		"rule":        map[string]interface{}{"ruleKey": "artifactMetadata.coverage", "ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": "high"}}},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, map[string]interface{}{
+		"environment": environment,
+		"mapping":     map[string]interface{}{"ruleId": primitive.NewObjectID().Hex(), "environments": map[string]interface{}{environment: true}},
+	})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// A mapping for another rule would leave the proposed rule out
+	rr = callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, map[string]interface{}{
+		"environment": environment,
+		"rule":        map[string]interface{}{"id": rule.ID.Hex(), "ruleKey": "artifactMetadata.coverage"},
+		"mapping":     map[string]interface{}{"ruleId": primitive.NewObjectID().Hex(), "environments": map[string]interface{}{environment: true}},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 5: A rule mapped at a pinned version
+
+	rr = callHandler(t, validation.UpdateRuleMapping, "PUT", "/validation/mappings/"+mapping.ID.Hex(), map[string]string{"id": mapping.ID.Hex()}, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"ruleVersion":  1,
+		"environments": map[string]interface{}{environment: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	tightened := map[string]interface{}{
+		"id":         rule.ID.Hex(),
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	}
+
+	// The pinned mapping keeps the stored version, so the change alone can't be simulated
+	rr = callHandler(t, validation.SimulatePolicy, "POST", "/validation/simulate", nil, map[string]interface{}{
+		"environment": environment,
+		"filter":      filter,
+		"rule":        tightened,
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "pinned")
+
+	// Proposing the mapping along with the rule simulates rolling the change out
+	result = simulate(map[string]interface{}{
+		"rule": tightened,
+		"mapping": map[string]interface{}{
+			"id":           mapping.ID.Hex(),
+			"ruleId":       rule.ID.Hex(),
+			"environments": map[string]interface{}{environment: true},
+		},
+	})
+	assert.Equal(t, 1, result.NewlyFailing)
+	assert.Equal(t, []string{ids["medium"]}, changed(result))
+
+}
+
+func TestRuleVersions(t *testing.T) {
+
+	database.SetupDatabase()
+
+	dev := "dev-" + generateRandomID(6)
+	prod := "prod-" + generateRandomID(6)
+
+	rr := callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 50}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var rule validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, rule.Version)
+
+	// dev tracks the latest version, prod is pinned to the first
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"environments": map[string]interface{}{dev: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"ruleVersion":  1,
+		"environments": map[string]interface{}{prod: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var prodMapping validation.ValidationRuleMapping
+	if err := json.Unmarshal(rr.Body.Bytes(), &prodMapping); err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := artifacts.Artifact{ID: primitive.NewObjectID(), ArtifactMetadata: map[string]interface{}{"coverage": 70}}
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	validate := func(environment string) validation.ValidationResult {
+		rr := callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  artifact.ID.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: Tightening the rule only reaches the environment tracking the latest version
+
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+		"name":       "coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var updated validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, "artifactMetadata.coverage", updated.RuleKey)
+
+	result := validate(dev)
+	assert.False(t, result.PassesValidation)
+	if assert.Len(t, result.Rules, 1) {
+		assert.Equal(t, 2, result.Rules[0].RuleVersion)
+	}
+
+	result = validate(prod)
+	assert.True(t, result.PassesValidation)
+	if assert.Len(t, result.Rules, 1) {
+		assert.Equal(t, 1, result.Rules[0].RuleVersion)
+	}
+
+	// The history records the version that was evaluated
+	rr = callHandler(t, validation.GetEnvironmentValidations, "GET", "/validation/results/environments/"+prod, map[string]string{"name": prod}, nil)
+	var records []validation.ValidationRecord
+	if err := json.Unmarshal(rr.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, records, 1) && assert.Len(t, records[0].RuleSet, 1) {
+		assert.Equal(t, 1, records[0].RuleSet[0].Version)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 2: Listing & fetching versions
+
+	rr = callHandler(t, validation.GetRuleVersions, "GET", "/validation/rules/"+rule.ID.Hex()+"/versions", map[string]string{"id": rule.ID.Hex()}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var versions []validation.RuleVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, 1, versions[0].Version)
+		assert.Equal(t, 2, versions[1].Version)
+		assert.Equal(t, "anonymous", versions[1].Actor)
+	}
+
+	rr = callHandler(t, validation.GetRuleVersion, "GET", "/validation/rules/"+rule.ID.Hex()+"/versions/1", map[string]string{"id": rule.ID.Hex(), "version": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var version validation.RuleVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &version); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(50), *version.Snapshot.RuleLimits[0].Value)
+
+	rr = callHandler(t, validation.GetRuleVersion, "GET", "/validation/rules/"+rule.ID.Hex()+"/versions/3", map[string]string{"id": rule.ID.Hex(), "version": "3"}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 3: Rolling the new version out to prod
+
+	rr = callHandler(t, validation.CreateRuleMapping, "POST", "/validation/mappings", nil, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"ruleVersion":  5,
+		"environments": map[string]interface{}{prod: true},
+	})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = callHandler(t, validation.UpdateRuleMapping, "PUT", "/validation/mappings/"+prodMapping.ID.Hex(), map[string]string{"id": prodMapping.ID.Hex()}, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"ruleVersion":  2,
+		"environments": map[string]interface{}{prod: true},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	result = validate(prod)
+	assert.False(t, result.PassesValidation)
+
+	// --------------------------------------------------------------------
+	// Test 4: An update made against an earlier version is refused, concurrent updates each get their own version or a 409
+
+	assert.Equal(t, database.ErrConflict, validation.GetRuleRepository().Update(context.Background(), rule.ID, 1, updated))
+
+	codes := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+rule.ID.Hex(), map[string]string{"id": rule.ID.Hex()}, map[string]interface{}{
+				"name":       "coverage",
+				"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 80 + i}},
+			})
+			codes[i] = rr.Code
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, code := range codes {
+		assert.Contains(t, []int{http.StatusOK, http.StatusConflict}, code)
+		if code == http.StatusOK {
+			accepted++
+		}
+	}
+
+	rr = callHandler(t, validation.GetRuleVersions, "GET", "/validation/rules/"+rule.ID.Hex()+"/versions", map[string]string{"id": rule.ID.Hex()}, nil)
+	versions = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, versions, 2+accepted) {
+		for i, version := range versions {
+			assert.Equal(t, i+1, version.Version)
+		}
+	}
+
+	// --------------------------------------------------------------------
+	// Test 5: A pin that can't be stored is reported rather than returned as a success
+
+	missing := primitive.NewObjectID().Hex()
+	rr = callHandler(t, validation.UpdateRuleMapping, "PUT", "/validation/mappings/"+missing, map[string]string{"id": missing}, map[string]interface{}{
+		"ruleId":       rule.ID.Hex(),
+		"ruleVersion":  1,
+		"environments": map[string]interface{}{prod: true},
+	})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+}
+
+func TestPolicyBundles(t *testing.T) {
+
+	database.SetupDatabase()
+
+	// An import replaces the whole policy, so the test starts from an empty policy
+	clearPolicy(t)
+	ctx := context.Background()
+
+	importBundle := func(url string, bundle string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(bundle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		validation.ImportPolicy(rr, req)
+		return rr
+	}
+
+	planOf := func(rr *httptest.ResponseRecorder) validation.PolicyPlan {
+		var plan validation.PolicyPlan
+		if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+
+	// --------------------------------------------------------------------
+	// Test 1: Importing a YAML bundle into an empty policy creates everything
+
+	bundle := `
+rules:
+  - name: coverage
+    ruleKey: artifactMetadata.coverage
+    ruleLimits:
+      - type: min
+        value: 50
+  - name: named
+    ruleKey: name
+    ruleLimits:
+      - type: set
+mappings:
+  - rule: coverage
+    environments: [ prod, preprod ]
+  - rule: named
+    environments: [ dev ]
+    enforced: false
+`
+	rr := importBundle("/validation/import", bundle)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	plan := planOf(rr)
+	assert.True(t, plan.Applied)
+	assert.Equal(t, 4, plan.Creates)
+	assert.Equal(t, 0, plan.Updates+plan.Deletes)
+
+	rules, _ := validation.GetRuleRepository().List(ctx)
+	assert.Len(t, rules, 2)
+	for _, rule := range rules {
+		assert.Equal(t, 1, rule.Version)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 2: The export refers to rules by name & round trips without changes
+
+	rr = callHandler(t, validation.ExportPolicy, "GET", "/validation/export?format=json", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var exported validation.PolicyBundle
+	if err := json.Unmarshal(rr.Body.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, exported.Mappings, 2) {
+		assert.Equal(t, "coverage", exported.Mappings[0].Rule)
+		assert.Equal(t, []string{"preprod", "prod"}, exported.Mappings[0].Environments)
+		assert.Nil(t, exported.Mappings[0].Enforced)
+		assert.False(t, *exported.Mappings[1].Enforced)
+	}
+
+	rr = callHandler(t, validation.ExportPolicy, "GET", "/validation/export", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/yaml", rr.Header().Get("Content-Type"))
+
+	rr = importBundle("/validation/import", rr.Body.String())
+	assert.Equal(t, http.StatusOK, rr.Code)
+	plan = planOf(rr)
+	assert.Equal(t, 0, plan.Creates+plan.Updates+plan.Deletes)
+
+	// --------------------------------------------------------------------
+	// Test 3: A plan shows the creates, updates & deletes without applying them
+
+	changed := `
+rules:
+  - name: coverage
+    ruleKey: artifactMetadata.coverage
+    ruleLimits:
+      - type: min
+        value: 80
+  - name: licensed
+    ruleKey: artifactMetadata.license
+    ruleLimits:
+      - type: set
+mappings:
+  - rule: coverage
+    environments: [ preprod, prod ]
+    ruleVersion: 2
+  - rule: licensed
+    environments: [ prod ]
+`
+	rr = importBundle("/validation/import?plan=true", changed)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	plan = planOf(rr)
+	assert.False(t, plan.Applied)
+	assert.Equal(t, 2, plan.Creates)
+	assert.Equal(t, 2, plan.Updates)
+	assert.Equal(t, 2, plan.Deletes)
+
+	actions := make(map[string]string)
+	for _, change := range plan.Rules {
+		actions[change.Name] = change.Action
+	}
+	assert.Equal(t, map[string]string{"coverage": "update", "licensed": "create", "named": "delete"}, actions)
+
+	actions = make(map[string]string)
+	for _, change := range plan.Mappings {
+		actions[change.Name] = change.Action
+	}
+	assert.Equal(t, map[string]string{"coverage@preprod,prod": "update", "licensed@prod": "create", "named@dev": "delete"}, actions)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	assert.Len(t, rules, 2)
+
+	// --------------------------------------------------------------------
+	// Test 4: Applying the plan bumps the updated rule's version
+
+	rr = importBundle("/validation/import", changed)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, planOf(rr).Applied)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	versions := make(map[string]int)
+	for _, rule := range rules {
+		versions[rule.Name] = rule.Version
+	}
+	assert.Equal(t, map[string]int{"coverage": 2, "licensed": 1}, versions)
+
+	mappings, _ := validation.GetMappingRepository().List(ctx)
+	assert.Len(t, mappings, 2)
+
+	// --------------------------------------------------------------------
+	// Test 5: Invalid bundles are rejected with the field at fault
+
+	rr = importBundle("/validation/import", "rules: [ { name: broken, ruleKey: name, ruleLimits: [ { type: between } ] } ]\nmappings: [ { rule: missing, environments: [ prod ] } ]")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	var rejected validation.RuleValidationError
+	if err := json.Unmarshal(rr.Body.Bytes(), &rejected); err != nil {
+		t.Fatal(err)
+	}
+	fields := make([]string, 0)
+	for _, fieldError := range rejected.Fields {
+		fields = append(fields, fieldError.Field)
+	}
+	assert.Contains(t, fields, "mappings[0].rule")
+	assert.Contains(t, fields[0], "rules[0].ruleLimits[0]")
+
+	rr = importBundle("/validation/import", "rules: [ { name: coverage, ruleKey: name, ruleLimit: [] } ]")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = importBundle("/validation/import", "rules: [ { name: coverage, ruleKey: name, ruleLimits: [ { type: set } ] } ]\nmappings: [ { rule: coverage, environments: [ prod ], ruleVersion: 9 } ]")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	assert.Len(t, rules, 2)
+
+	// --------------------------------------------------------------------
+	// Test 6: Rules are written exactly as the bundle defines them, so a dropped ruleKey stays dropped
+
+	expressionOnly := `
+rules:
+  - name: coverage
+    expression: "has(artifactMetadata.coverage) && artifactMetadata.coverage >= 80"
+mappings:
+  - rule: coverage
+    environments: [ preprod, prod ]
+`
+	rr = importBundle("/validation/import", expressionOnly)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2, planOf(rr).Updates) // the rule, and the mapping no longer pinned
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	if assert.Len(t, rules, 1) {
+		assert.Empty(t, rules[0].RuleKey)
+	}
+
+	rr = importBundle("/validation/import?plan=true", expressionOnly)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	plan = planOf(rr)
+	assert.Equal(t, 0, plan.Creates+plan.Updates+plan.Deletes)
+
+	// --------------------------------------------------------------------
+	// Test 7: A change that fails part way undoes the changes already made
+
+	base := `
+rules:
+  - name: coverage
+    expression: "has(artifactMetadata.coverage) && artifactMetadata.coverage >= 80"
+  - name: named
+    ruleKey: name
+    ruleLimits:
+      - type: set
+mappings:
+  - rule: coverage
+    environments: [ preprod, prod ]
+`
+	rr = importBundle("/validation/import", base)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	parsed, err := validation.ParseBundle([]byte(`
+rules:
+  - name: coverage
+    expression: "has(artifactMetadata.coverage) && artifactMetadata.coverage >= 90"
+  - name: added
+    ruleKey: artifactType
+    ruleLimits:
+      - type: set
+  - name: named
+    ruleKey: description
+    ruleLimits:
+      - type: set
+mappings:
+  - rule: added
+    environments: [ dev ]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, fieldErrors, err := validation.PlanBundle(ctx, *parsed)
+	if err != nil || len(fieldErrors) > 0 {
+		t.Fatal(err, fieldErrors)
+	}
+
+	// named is changed after the plan was made, so its update conflicts once coverage & added have been written
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	before := make(map[string]validation.ValidationRule)
+	for _, rule := range rules {
+		before[rule.Name] = rule
+	}
+	named := before["named"]
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+named.ID.Hex(), map[string]string{"id": named.ID.Hex()}, map[string]interface{}{
+		"name":       "named",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "absent"}},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	err = validation.ApplyPlan(ctx, "test", pending)
+	assert.ErrorIs(t, err, database.ErrConflict)
+	var applyError *validation.ApplyError
+	if assert.ErrorAs(t, err, &applyError) {
+		assert.Empty(t, applyError.Applied)
+	}
+	assert.False(t, pending.Applied)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	if assert.Len(t, rules, 2) {
+		for _, rule := range rules {
+			switch rule.Name {
+			case "coverage":
+				// The update & its undo are both versions of the rule
+				assert.Equal(t, before["coverage"].Expression, rule.Expression)
+				assert.Equal(t, before["coverage"].Version+2, rule.Version)
+			case "named":
+				assert.Equal(t, "absent", rule.RuleLimits[0].Type)
+			default:
+				t.Errorf("rule %s should have been removed", rule.Name)
+			}
+		}
+	}
+	mappings, _ = validation.GetMappingRepository().List(ctx)
+	assert.Len(t, mappings, 1)
+
+	// --------------------------------------------------------------------
+	// Test 8: Mappings of deleted rules are reported rather than dropped from the export
+
+	orphanRule := createMappedRule(t, map[string]interface{}{"name": "orphaned", "ruleKey": "name", "ruleLimits": []interface{}{map[string]interface{}{"type": "set"}}}, map[string]interface{}{"environments": map[string]interface{}{"dev": true}})
+	rr = callHandler(t, validation.DeleteRule, "DELETE", "/validation/rules/"+orphanRule, map[string]string{"id": orphanRule}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.ExportPolicy, "GET", "/validation/export", nil, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "rule "+orphanRule)
+
+}
+
+func TestPolicySync(t *testing.T) {
+
+	database.SetupDatabase()
+
+	// The sync replaces the whole policy, so the test starts from an empty policy
+	clearPolicy(t)
+	ctx := context.Background()
+
+	// Rules & mappings can be split across files & directories, hidden directories are skipped
+	directory := t.TempDir()
+	writeFile := func(name string, content string) {
+		path := directory + "/" + name
+		if err := os.MkdirAll(path[:strings.LastIndex(path, "/")], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("rules.yaml", `
+rules:
+  - name: coverage
+    ruleKey: artifactMetadata.coverage
+    ruleLimits:
+      - type: min
+        value: 50
+`)
+	writeFile("team/mappings.yaml", `
+mappings:
+  - rule: coverage
+    environments: [ prod ]
+`)
+	writeFile(".git/config.yaml", "not: [ a bundle")
+
+	defer validation.ConfigurePolicySync(validation.PolicySyncConfig{})
+
+	// --------------------------------------------------------------------
+	// Test 1: A dry run reports the drift without changing anything
+
+	validation.ConfigurePolicySync(validation.PolicySyncConfig{Directory: directory, DryRun: true, ReadOnly: true})
+	status := validation.SyncPolicy(ctx)
+	assert.Empty(t, status.Errors)
+	assert.False(t, status.InSync)
+	assert.Equal(t, []string{"rules.yaml", "team/mappings.yaml"}, status.Files)
+	if assert.NotNil(t, status.Drift) {
+		assert.Equal(t, 2, status.Drift.Creates)
+		assert.False(t, status.Drift.Applied)
+	}
+
+	rules, _ := validation.GetRuleRepository().List(ctx)
+	assert.Len(t, rules, 0)
+
+	// --------------------------------------------------------------------
+	// Test 2: The sync reconciles the policy & the API can't change it
+
+	validation.ConfigurePolicySync(validation.PolicySyncConfig{Directory: directory, ReadOnly: true})
+	rr := callHandler(t, validation.TriggerPolicySync, "POST", "/validation/sync", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, status.InSync)
+	assert.NotNil(t, status.LastApplied)
+	assert.True(t, status.Drift.Applied)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, "coverage", rules[0].Name)
+	}
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{
+		"name":       "named",
+		"ruleKey":    "name",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "set"}},
+	})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = callHandler(t, validation.DeleteRule, "DELETE", "/validation/rules/"+rules[0].ID.Hex(), map[string]string{"id": rules[0].ID.Hex()}, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = callHandler(t, validation.ImportPolicy, "POST", "/validation/import", nil, validation.PolicyBundle{})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Planning an import changes nothing, so it's allowed
+	rr = callHandler(t, validation.ImportPolicy, "POST", "/validation/import?plan=true", nil, validation.PolicyBundle{})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var planned validation.PolicyPlan
+	if err := json.Unmarshal(rr.Body.Bytes(), &planned); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, planned.Applied)
+	assert.Equal(t, 2, planned.Deletes)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	assert.Len(t, rules, 1)
+
+	// Waivers aren't part of the policy & can still be granted
+	rr = callHandler(t, validation.CreateWaiver, "POST", "/validation/waivers", nil, map[string]interface{}{
+		"ruleId":         rules[0].ID.Hex(),
+		"artifactFamily": "payments-api",
+		"environments":   []string{"prod"},
+		"expiresAt":      time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+		"justification":  "fix scheduled",
+		"approver":       "security-team",
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// --------------------------------------------------------------------
+	// Test 3: Changes made behind the sync's back are reverted
+
+	mappings, _ := validation.GetMappingRepository().List(ctx)
+	if assert.Len(t, mappings, 1) {
+		validation.GetMappingRepository().Delete(ctx, mappings[0].ID)
+	}
+
+	status = validation.SyncPolicy(ctx)
+	assert.True(t, status.InSync)
+	if assert.NotNil(t, status.Drift) {
+		assert.Equal(t, 1, status.Drift.Creates)
+	}
+
+	status = validation.SyncPolicy(ctx)
+	assert.True(t, status.InSync)
+	assert.Nil(t, status.Drift)
+
+	// --------------------------------------------------------------------
+	// Test 4: Invalid files are reported against the file & leave the policy alone
+
+	writeFile("team/mappings.yaml", `
+mappings:
+  - rule: coverage
+    environments: [ prod ]
+  - rule: licensed
+    environments: [ prod ]
+`)
+	status = validation.SyncPolicy(ctx)
+	assert.False(t, status.InSync)
+	if assert.Len(t, status.Errors, 1) {
+		assert.Contains(t, status.Errors[0], "team/mappings.yaml: mappings[1].rule")
+	}
+
+	rr = callHandler(t, validation.GetPolicySync, "GET", "/validation/sync", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "no rule named licensed")
+
+	mappings, _ = validation.GetMappingRepository().List(ctx)
+	assert.Len(t, mappings, 1)
+
+	// --------------------------------------------------------------------
+	// Test 5: Changing a rule's dependencies or dropping its ruleKey is applied once, the next sync finds no drift
+
+	writeFile("rules.yaml", `
+rules:
+  - name: coverage
+    expression: "has(artifactMetadata.coverage) && artifactMetadata.coverage >= 50"
+    dependencies:
+      require: promoted
+`)
+	writeFile("team/mappings.yaml", `
+mappings:
+  - rule: coverage
+    environments: [ prod ]
+`)
+	status = validation.SyncPolicy(ctx)
+	assert.Empty(t, status.Errors)
+	if assert.NotNil(t, status.Drift) {
+		assert.Equal(t, 1, status.Drift.Updates)
+	}
+
+	status = validation.SyncPolicy(ctx)
+	assert.True(t, status.InSync)
+	assert.Nil(t, status.Drift)
+
+	rules, _ = validation.GetRuleRepository().List(ctx)
+	if assert.Len(t, rules, 1) {
+		assert.Empty(t, rules[0].RuleKey)
+		assert.Equal(t, "promoted", rules[0].Dependencies.Require)
+		assert.Equal(t, 2, rules[0].Version)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 6: Without a directory the sync is disabled & the API is writable again
+
+	validation.ConfigurePolicySync(validation.PolicySyncConfig{})
+	rr = callHandler(t, validation.TriggerPolicySync, "POST", "/validation/sync", nil, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = callHandler(t, validation.DeleteRuleMapping, "DELETE", "/validation/mappings/"+mappings[0].ID.Hex(), map[string]string{"id": mappings[0].ID.Hex()}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+}
+
+func TestDependencyRules(t *testing.T) {
+
+	database.SetupDatabase()
+
+	environment := "prod-" + generateRandomID(6)
+	staging := "staging-" + generateRandomID(6)
+
+	createMappedRule(t, map[string]interface{}{
+		"name":       "coverage",
+		"ruleKey":    "artifactMetadata.coverage",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "min", "value": 50}},
+	}, map[string]interface{}{"environments": map[string]interface{}{environment: true}})
+	createMappedRule(t, map[string]interface{}{"name": "dependencies", "dependencies": map[string]interface{}{}}, map[string]interface{}{"environments": map[string]interface{}{environment: true}})
+	createMappedRule(t, map[string]interface{}{"name": "promoted-dependencies", "dependencies": map[string]interface{}{"require": "promoted"}}, map[string]interface{}{"environments": map[string]interface{}{staging: true}})
+
+	createArtifact := func(name string, coverage int, dependencies ...primitive.ObjectID) primitive.ObjectID {
+		artifact := artifacts.Artifact{ID: primitive.NewObjectID(), Name: name, ArtifactMetadata: map[string]interface{}{"coverage": coverage}}
+		for _, dependency := range dependencies {
+			artifact.Dependencies = append(artifact.Dependencies, dependency.Hex())
+		}
+		rr := callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifact)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return artifact.ID
+	}
+
+	validate := func(id primitive.ObjectID, environment string) validation.ValidationResult {
+		rr := callHandler(t, validation.ValidateArtifact, "POST", "/validation/artifacts", nil, validation.ValidationRequest{
+			ArtifactID:  id.Hex(),
+			Environment: environment,
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result validation.ValidationResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	dependencyChecks := func(result validation.ValidationResult) []validation.CheckResult {
+		for _, rule := range result.Rules {
+			if rule.Name == "dependencies" || rule.Name == "promoted-dependencies" {
+				return rule.Checks
+			}
+		}
+		return nil
+	}
+
+	library := createArtifact("library", 70)
+	untested := createArtifact("untested", 10)
+
+	// --------------------------------------------------------------------
+	// Test 1: An artifact passes when its dependencies pass in the environment
+
+	service := createArtifact("service", 80, library)
+	result := validate(service, environment)
+	assert.True(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Equal(t, library.Hex(), checks[0].Key)
+		assert.Equal(t, "passed", checks[0].Actual)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 2: The failing dependency is reported, through every level of the graph
+
+	broken := createArtifact("broken", 80, library, untested)
+	result = validate(broken, environment)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 2) {
+		assert.True(t, checks[0].Passed)
+		assert.False(t, checks[1].Passed)
+		assert.Equal(t, untested.Hex(), checks[1].Key)
+		assert.Contains(t, checks[1].Message, "dependency "+untested.Hex()+" (untested) does not pass validation")
+		assert.Contains(t, checks[1].Message, "(coverage)")
+	}
+
+	app := createArtifact("app", 80, broken)
+	result = validate(app, environment)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Contains(t, checks[0].Message, "dependency "+broken.Hex()+" (broken)")
+		assert.Contains(t, checks[0].Message, "dependency "+untested.Hex()+" (untested)")
+	}
+
+	// --------------------------------------------------------------------
+	// Test 3: A dependency already promoted to the environment passes without being validated
+
+	if err := artifacts.GetRepository().AddPromotion(context.Background(), untested, artifacts.Promotion{Environment: environment, Timestamp: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+
+	result = validate(app, environment)
+	assert.True(t, result.PassesValidation)
+
+	// Requiring promotion fails dependencies that would pass validation
+	result = validate(service, staging)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Contains(t, checks[0].Message, "has not been promoted to "+staging)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 4: Cycles & missing dependencies fail rather than recursing forever
+
+	first := createArtifact("first", 80)
+	second := createArtifact("second", 80, first)
+	rr := callHandler(t, artifacts.UpdateArtifact, "PUT", "/artifacts/"+first.Hex(), map[string]string{"id": first.Hex()}, artifacts.Artifact{
+		Name:             "first",
+		ArtifactMetadata: map[string]interface{}{"coverage": 80},
+		Dependencies:     []string{second.Hex()},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	result = validate(first, environment)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Contains(t, checks[0].Message, "dependency cycle "+first.Hex()+" -> "+second.Hex()+" -> "+first.Hex())
+	}
+
+	orphan := createArtifact("orphan", 80, primitive.NewObjectID())
+	result = validate(orphan, environment)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Equal(t, "missing", checks[0].Actual)
+	}
+
+	// --------------------------------------------------------------------
+	// Test 5: Dependencies must be artifact IDs & requirements must be known
+
+	rr = callHandler(t, artifacts.CreateArtifact, "POST", "/artifacts", nil, artifacts.Artifact{Name: "invalid", Dependencies: []string{"library"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, artifacts.UpdateArtifact, "PUT", "/artifacts/"+first.Hex(), map[string]string{"id": first.Hex()}, artifacts.Artifact{Dependencies: []string{first.Hex()}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{"name": "invalid", "dependencies": map[string]interface{}{"require": "released"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "dependencies.require")
+
+	// --------------------------------------------------------------------
+	// Test 6: A rule's dependency requirement can be changed & removed
+
+	rr = callHandler(t, validation.CreateRule, "POST", "/validation/rules", nil, map[string]interface{}{"name": "changing", "dependencies": map[string]interface{}{}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var changing validation.ValidationRule
+	if err := json.Unmarshal(rr.Body.Bytes(), &changing); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+changing.ID.Hex(), map[string]string{"id": changing.ID.Hex()}, map[string]interface{}{"name": "changing", "dependencies": map[string]interface{}{"require": "promoted"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callHandler(t, validation.GetRule, "GET", "/validation/rules/"+changing.ID.Hex(), map[string]string{"id": changing.ID.Hex()}, nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &changing); err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, changing.Dependencies) {
+		assert.Equal(t, "promoted", changing.Dependencies.Require)
+	}
+
+	rr = callHandler(t, validation.UpdateRule, "PUT", "/validation/rules/"+changing.ID.Hex(), map[string]string{"id": changing.ID.Hex()}, map[string]interface{}{"name": "changing", "expression": "true"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var removed validation.ValidationRule
+	rr = callHandler(t, validation.GetRule, "GET", "/validation/rules/"+changing.ID.Hex(), map[string]string{"id": changing.ID.Hex()}, nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &removed); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, removed.Dependencies)
+	assert.Equal(t, 3, removed.Version)
+
+	// --------------------------------------------------------------------
+	// Test 7: Dependencies can be checked by list limits & expressions
+
+	samples := []artifacts.Artifact{
+		{Name: "one", Dependencies: []string{library.Hex()}},
+		{Name: "two", Dependencies: []string{library.Hex(), untested.Hex()}},
+		{Name: "none"},
+	}
+	testRule := func(rule map[string]interface{}) []bool {
+		rr := callHandler(t, validation.TestUnsavedRule, "POST", "/validation/rules/test", nil, map[string]interface{}{"rule": rule, "artifacts": samples})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var tested validation.RuleTestResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &tested); err != nil {
+			t.Fatal(err)
+		}
+		var passed []bool
+		for _, result := range tested.Results {
+			passed = append(passed, result.Passed)
+		}
+		return passed
+	}
+
+	assert.Equal(t, []bool{true, false, false}, testRule(map[string]interface{}{
+		"ruleKey":    "dependencies",
+		"ruleLimits": []interface{}{map[string]interface{}{"type": "count", "value": map[string]interface{}{"max": 1}}},
+	}))
+	assert.Equal(t, []bool{true, false, true}, testRule(map[string]interface{}{"expression": "size(dependencies) <= 1"}))
+	assert.Equal(t, []bool{false, true, false}, testRule(map[string]interface{}{"expression": "'" + untested.Hex() + "' in dependencies"}))
+
+	// --------------------------------------------------------------------
+	// Test 8: The walk stops once it has read 100 artifacts, a longer chain fails
+
+	chain := createArtifact("link-1", 80)
+	for i := 2; i <= 100; i++ {
+		chain = createArtifact(fmt.Sprintf("link-%d", i), 80, chain)
+	}
+
+	// 100 dependencies are read in full
+	head := createArtifact("head", 80, chain)
+	result = validate(head, environment)
+	assert.True(t, result.PassesValidation)
+
+	result = validate(createArtifact("beyond", 80, head), environment)
+	assert.False(t, result.PassesValidation)
+	if checks := dependencyChecks(result); assert.Len(t, checks, 1) {
+		assert.Contains(t, checks[0].Message, "the dependency graph has more than 100 artifacts")
+	}
+
+}
@@ -0,0 +1,146 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	database "artifactflow.com/m/v2/cmd/database"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Number of artifact & environment pairs evaluated at once
+const batchWorkers = 8
+
+// Validate many artifacts, given by ID or a search filter, against many environments
+type BatchValidationRequest struct {
+	ArtifactIDs  []string               `json:"artifactIds,omitempty"`
+	Filter       *database.SearchFilter `json:"filter,omitempty"`
+	Environments []string               `json:"environments"`
+}
+
+type BatchValidationResult struct {
+	PassesValidation bool                                    `json:"passesValidation"` // every artifact passes every environment
+	Results          map[string]map[string]*ValidationResult `json:"results"`          // artifact ID -> environment -> result
+	Errors           map[string]string                       `json:"errors,omitempty"` // artifact IDs that couldn't be loaded
+}
+
+// Validate a batch of Artifacts against a set of Environments
+func ValidateArtifacts(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Validating a batch of Artifacts")
+
+	var req BatchValidationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", 422)
+		log.Println(err)
+		return
+	}
+
+	if len(req.Environments) == 0 {
+		http.Error(w, "At least one environment is required", 422)
+		return
+	}
+	if (len(req.ArtifactIDs) == 0) == (req.Filter == nil) {
+		http.Error(w, "Exactly one of artifactIds or filter is required", 422)
+		return
+	}
+
+	result := &BatchValidationResult{
+		PassesValidation: true,
+		Results:          make(map[string]map[string]*ValidationResult),
+	}
+
+	// Load the artifacts
+	var batch []artifacts.Artifact
+	if req.Filter != nil {
+		found, err := artifacts.GetRepository().Search(r.Context(), *req.Filter)
+		if err != nil {
+			http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		batch = found
+	} else {
+		for _, artifactID := range req.ArtifactIDs {
+			id, err := primitive.ObjectIDFromHex(artifactID)
+			if err != nil {
+				result.addError(artifactID, "invalid artifact ID")
+				continue
+			}
+			artifact, err := artifacts.GetRepository().Get(r.Context(), id)
+			if err == database.ErrNotFound {
+				result.addError(artifactID, "artifact not found")
+				continue
+			}
+			if err != nil {
+				http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+			batch = append(batch, *artifact)
+		}
+	}
+
+	// Load the rules for each environment once, rules mapped to several environments are only read once
+	cache := make(ruleCache)
+	rules := make(map[string][]mappedRule)
+	for _, environment := range req.Environments {
+		if _, ok := rules[environment]; ok {
+			continue
+		}
+		mapped, err := loadMappedRules(r.Context(), environment, cache)
+		if err != nil {
+			http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		rules[environment] = mapped
+	}
+
+	for _, artifact := range batch {
+		result.Results[artifact.ID.Hex()] = make(map[string]*ValidationResult)
+	}
+
+	// Evaluate every artifact & environment pair concurrently
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, batchWorkers)
+
+	for i := range batch {
+		for environment, mapped := range rules {
+			wg.Add(1)
+			workers <- struct{}{}
+			go func(artifact *artifacts.Artifact, environment string, mapped []mappedRule) {
+				defer wg.Done()
+				defer func() { <-workers }()
+
+				applicable := applicableRules(*artifact, mapped)
+				validationResult := evaluateAgainstRules(r.Context(), artifact, environment, applicable)
+				recordValidation(r, validationResult, applicable, SourceBatch)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				result.Results[artifact.ID.Hex()][environment] = validationResult
+				if !validationResult.PassesValidation {
+					result.PassesValidation = false
+				}
+			}(&batch[i], environment, mapped)
+		}
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Artifacts that couldn't be loaded fail the batch
+func (result *BatchValidationResult) addError(artifactID string, message string) {
+	if result.Errors == nil {
+		result.Errors = make(map[string]string)
+	}
+	result.Errors[artifactID] = message
+	result.PassesValidation = false
+}
@@ -0,0 +1,634 @@
+package validation
+
+import (
+	auth "artifactflow.com/m/v2/cmd/auth"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"log"
+	"net/http"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// PolicyBundle is the declarative form of every Validation Rule & Mapping, written as YAML or JSON
+// Rules are referred to by name rather than ID, so a bundle can be reviewed & applied to any server
+type PolicyBundle struct {
+	Rules    []BundleRule    `json:"rules"`
+	Mappings []BundleMapping `json:"mappings"`
+}
+
+// A Validation Rule within a bundle, identified by its name
+type BundleRule struct {
+	Name         string                 `json:"name"` // unique, rules without a name are exported under their ID
+	Description  string                 `json:"description,omitempty"`
+	RuleFamily   string                 `json:"ruleFamily,omitempty"`
+	RuleKey      string                 `json:"ruleKey,omitempty"`
+	RuleLimits   []RuleLimit            `json:"ruleLimits,omitempty"`
+	Condition    *Condition             `json:"condition,omitempty"`
+	Expression   Expression             `json:"expression,omitempty"`
+	Rego         string                 `json:"rego,omitempty"`
+	Selector     *ArtifactSelector      `json:"selector,omitempty"`
+	Dependencies *DependencyRequirement `json:"dependencies,omitempty"`
+}
+
+// A Validation Rule Mapping within a bundle, identified by its rule & environments
+type BundleMapping struct {
+	Rule         string            `json:"rule"`         // the name of a rule in the bundle
+	Environments []string          `json:"environments"` // [ "preprod", "prod" ]
+	Enforced     *bool             `json:"enforced,omitempty"`
+	RuleVersion  int               `json:"ruleVersion,omitempty"`
+	Selector     *ArtifactSelector `json:"selector,omitempty"`
+}
+
+// The changes needed to make the stored rules & mappings match a bundle, records that already match are left out
+type PolicyPlan struct {
+	Applied  bool            `json:"applied"` // false when only planned
+	Creates  int             `json:"creates"`
+	Updates  int             `json:"updates"`
+	Deletes  int             `json:"deletes"`
+	Rules    []RuleChange    `json:"rules"`
+	Mappings []MappingChange `json:"mappings"`
+}
+
+// Rules are referred to by name in a bundle, so stored names have to be unique to export
+var ErrDuplicateRuleName = errors.New("more than one rule has the same name")
+
+// Mappings are exported under their rule's name, so a mapping whose rule was deleted can't be exported
+var ErrOrphanedMapping = errors.New("mapping refers to a rule that doesn't exist")
+
+// ApplyError is returned when a plan fails part way, the changes already made are undone newest first
+// Applied lists the changes that couldn't be undone & are still in place, e.g. create rule coverage
+type ApplyError struct {
+	Err     error
+	Applied []string
+}
+
+func (applyError *ApplyError) Error() string {
+	if len(applyError.Applied) == 0 {
+		return fmt.Sprintf("%v, the changes already made were undone", applyError.Err)
+	}
+	return fmt.Sprintf("%v, these changes could not be undone: %s", applyError.Err, strings.Join(applyError.Applied, ", "))
+}
+
+func (applyError *ApplyError) Unwrap() error {
+	return applyError.Err
+}
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+type RuleChange struct {
+	Action string      `json:"action"`           // create / update / delete
+	Name   string      `json:"name"`             // the rule name
+	Before *BundleRule `json:"before,omitempty"` // as stored, unset for creates
+	After  *BundleRule `json:"after,omitempty"`  // as in the bundle, unset for deletes
+	stored *ValidationRule
+}
+
+type MappingChange struct {
+	Action string         `json:"action"`           // create / update / delete
+	Name   string         `json:"name"`             // the rule name & environments, e.g. coverage@preprod,prod
+	Before *BundleMapping `json:"before,omitempty"` // as stored, unset for creates
+	After  *BundleMapping `json:"after,omitempty"`  // as in the bundle, unset for deletes
+	stored *ValidationRuleMapping
+}
+
+// --------------------------------------------
+// Export & Import
+// --------------------------------------------
+
+// Export every Validation Rule & Mapping as a bundle
+func ExportPolicy(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Exporting the validation policy")
+
+	bundle, err := ExportBundle(r.Context())
+	if errors.Is(err, ErrDuplicateRuleName) || errors.Is(err, ErrOrphanedMapping) {
+		http.Error(w, "Unable to export the validation policy, "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to export the validation policy", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(bundle)
+		return
+	}
+
+	out, err := yaml.Marshal(bundle)
+	if err != nil {
+		http.Error(w, "Unable to export the validation policy", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
+
+// Import a bundle, replacing every Validation Rule & Mapping with its contents
+// With ?plan=true the changes are returned without being applied
+func ImportPolicy(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Importing a validation policy")
+
+	// Planning doesn't change anything, so it's allowed while the policy is read-only
+	planOnly := r.URL.Query().Get("plan") == "true"
+	if !planOnly && policyReadOnly(w) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read the request body", 422)
+		log.Println(err)
+		return
+	}
+
+	bundle, err := ParseBundle(body)
+	if err != nil {
+		http.Error(w, "Unable to decode the policy bundle: "+err.Error(), 422)
+		log.Println(err)
+		return
+	}
+
+	plan, fieldErrors, err := PlanBundle(r.Context(), *bundle)
+	if err != nil {
+		http.Error(w, "Unable to plan the policy import", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if len(fieldErrors) > 0 {
+		rejectFields(w, "Invalid policy bundle", fieldErrors)
+		return
+	}
+
+	if !planOnly {
+		err := ApplyPlan(r.Context(), auth.GetActor(r), plan)
+		if errors.Is(err, database.ErrConflict) {
+			http.Error(w, "Unable to apply the policy import, "+err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Unable to apply the policy import, "+err.Error(), http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// --------------------------------------------
+// Supporting Functions
+// --------------------------------------------
+
+// Parse a YAML or JSON bundle, unknown fields are rejected so typos don't silently drop part of a rule
+func ParseBundle(data []byte) (*PolicyBundle, error) {
+	var bundle PolicyBundle
+	if err := yaml.UnmarshalStrict(data, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// Build the bundle for the stored rules & mappings, sorted so the output is stable
+func ExportBundle(ctx context.Context) (*PolicyBundle, error) {
+	rules, err := GetRuleRepository().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := GetMappingRepository().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &PolicyBundle{Rules: []BundleRule{}, Mappings: []BundleMapping{}}
+	names := make(map[primitive.ObjectID]string)
+	for _, rule := range rules {
+		bundled := bundleRule(rule)
+		for _, name := range names {
+			if name == bundled.Name {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateRuleName, name)
+			}
+		}
+		names[rule.ID] = bundled.Name
+		bundle.Rules = append(bundle.Rules, bundled)
+	}
+	var orphaned []string
+	for _, mapping := range mappings {
+		name, ok := names[mapping.RuleId]
+		if !ok {
+			orphaned = append(orphaned, fmt.Sprintf("%s (rule %s)", mapping.ID.Hex(), mapping.RuleId.Hex()))
+			continue
+		}
+		bundle.Mappings = append(bundle.Mappings, bundleMapping(mapping, name))
+	}
+	if len(orphaned) > 0 {
+		return nil, fmt.Errorf("%w: %s, delete the mappings to export", ErrOrphanedMapping, strings.Join(orphaned, ", "))
+	}
+
+	for i := range bundle.Mappings {
+		bundle.Mappings[i].normalize()
+	}
+	sort.SliceStable(bundle.Rules, func(i, j int) bool { return bundle.Rules[i].Name < bundle.Rules[j].Name })
+	sort.SliceStable(bundle.Mappings, func(i, j int) bool { return bundle.Mappings[i].key() < bundle.Mappings[j].key() })
+	return bundle, nil
+}
+
+// Compare a bundle with the stored rules & mappings, returning the changes or the problems found in the bundle
+func PlanBundle(ctx context.Context, bundle PolicyBundle) (*PolicyPlan, []FieldError, error) {
+	fieldErrors := bundle.validate()
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors, nil
+	}
+	for i := range bundle.Mappings {
+		bundle.Mappings[i].normalize()
+	}
+
+	rules, err := GetRuleRepository().List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	mappings, err := GetMappingRepository().List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan := &PolicyPlan{Rules: []RuleChange{}, Mappings: []MappingChange{}}
+
+	// Rules are matched by name, the version each will have once applied is needed to check pinned mappings
+	storedRules := make(map[string]*ValidationRule)
+	names := make(map[primitive.ObjectID]string)
+	for i := range rules {
+		name := bundleRule(rules[i]).Name
+		if _, duplicate := storedRules[name]; duplicate {
+			return nil, []FieldError{{Field: "rules", Message: fmt.Sprintf("more than one stored rule is named %s, rename one before importing", name)}}, nil
+		}
+		storedRules[name] = &rules[i]
+		names[rules[i].ID] = name
+	}
+
+	versions := make(map[string]int)
+	for i := range bundle.Rules {
+		wanted := bundle.Rules[i]
+		stored, ok := storedRules[wanted.Name]
+		switch {
+		case !ok:
+			plan.Rules = append(plan.Rules, RuleChange{Action: ActionCreate, Name: wanted.Name, After: &wanted})
+			versions[wanted.Name] = 1
+		case !sameJSON(bundleRule(*stored), wanted):
+			before := bundleRule(*stored)
+			plan.Rules = append(plan.Rules, RuleChange{Action: ActionUpdate, Name: wanted.Name, Before: &before, After: &wanted, stored: stored})
+			versions[wanted.Name] = stored.Version + 1
+		default:
+			versions[wanted.Name] = stored.Version
+		}
+	}
+	for i := range rules {
+		name := names[rules[i].ID]
+		if _, ok := versions[name]; !ok {
+			before := bundleRule(rules[i])
+			plan.Rules = append(plan.Rules, RuleChange{Action: ActionDelete, Name: name, Before: &before, stored: &rules[i]})
+		}
+	}
+
+	for i, mapping := range bundle.Mappings {
+		if mapping.RuleVersion > versions[mapping.Rule] {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("mappings[%d].ruleVersion", i), Message: fmt.Sprintf("rule %s will only have %d version(s)", mapping.Rule, versions[mapping.Rule])})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors, nil
+	}
+
+	// Mappings are matched by rule name & environments, a stored mapping can only match one bundle mapping
+	wantedMappings := make(map[string]BundleMapping)
+	for _, mapping := range bundle.Mappings {
+		wantedMappings[mapping.key()] = mapping
+	}
+	matched := make(map[string]bool)
+	for i := range mappings {
+		stored := mappings[i]
+		name, ok := names[stored.RuleId]
+		current := bundleMapping(stored, name)
+		wanted, found := wantedMappings[current.key()]
+		switch {
+		case !ok || !found || matched[current.key()]:
+			plan.Mappings = append(plan.Mappings, MappingChange{Action: ActionDelete, Name: current.key(), Before: &current, stored: &stored})
+		case !sameJSON(current, wanted):
+			matched[current.key()] = true
+			plan.Mappings = append(plan.Mappings, MappingChange{Action: ActionUpdate, Name: current.key(), Before: &current, After: &wanted, stored: &stored})
+		default:
+			matched[current.key()] = true
+		}
+	}
+	for i := range bundle.Mappings {
+		wanted := bundle.Mappings[i]
+		if !matched[wanted.key()] {
+			plan.Mappings = append(plan.Mappings, MappingChange{Action: ActionCreate, Name: wanted.key(), After: &wanted})
+		}
+	}
+
+	for _, change := range plan.Rules {
+		plan.count(change.Action)
+	}
+	for _, change := range plan.Mappings {
+		plan.count(change.Action)
+	}
+	return plan, nil, nil
+}
+
+// Apply a plan, rules are created & updated first so new mappings can refer to them, deletes happen last
+// The store has no transactions, when a change fails the changes already made are undone & an *ApplyError is returned
+// Undoing a rule update records the stored rule as a further version, so the rule's history shows both changes
+func ApplyPlan(ctx context.Context, actor string, plan *PolicyPlan) error {
+	ruleIDs := make(map[string]primitive.ObjectID)
+	rules, err := GetRuleRepository().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		ruleIDs[bundleRule(rule).Name] = rule.ID
+	}
+
+	var applied []appliedChange
+	apply := func(description string, change func() (func() error, error)) error {
+		undo, err := change()
+		if err != nil {
+			return undoChanges(applied, fmt.Errorf("unable to %s: %w", description, err))
+		}
+		applied = append(applied, appliedChange{description: description, undo: undo})
+		return nil
+	}
+
+	for _, change := range plan.Rules {
+		change := change
+		switch change.Action {
+		case ActionCreate:
+			err = apply("create rule "+change.Name, func() (func() error, error) {
+				created, err := createVersionedRule(ctx, actor, change.After.rule())
+				if err != nil {
+					return nil, err
+				}
+				ruleIDs[change.Name] = created.ID
+				return func() error { return GetRuleRepository().Delete(ctx, created.ID) }, nil
+			})
+		case ActionUpdate:
+			err = apply("update rule "+change.Name, func() (func() error, error) {
+				updated, err := updateVersionedRule(ctx, actor, *change.stored, change.After.rule())
+				if err != nil {
+					return nil, err
+				}
+				return func() error {
+					_, err := updateVersionedRule(ctx, actor, *updated, *change.stored)
+					return err
+				}, nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, change := range plan.Mappings {
+		change := change
+		switch change.Action {
+		case ActionCreate:
+			err = apply("create mapping "+change.Name, func() (func() error, error) {
+				id, err := GetMappingRepository().Create(ctx, change.After.mapping(ruleIDs[change.After.Rule]))
+				if err != nil {
+					return nil, err
+				}
+				return func() error { return GetMappingRepository().Delete(ctx, id) }, nil
+			})
+		case ActionUpdate:
+			err = apply("update mapping "+change.Name, func() (func() error, error) {
+				if err := GetMappingRepository().Update(ctx, change.stored.ID, change.After.mapping(ruleIDs[change.After.Rule])); err != nil {
+					return nil, err
+				}
+				return func() error { return GetMappingRepository().Update(ctx, change.stored.ID, *change.stored) }, nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Deleted records are put back with their IDs, so mappings & pinned versions still refer to them
+	for _, change := range plan.Mappings {
+		change := change
+		if change.Action == ActionDelete {
+			err = apply("delete mapping "+change.Name, func() (func() error, error) {
+				if err := GetMappingRepository().Delete(ctx, change.stored.ID); err != nil {
+					return nil, err
+				}
+				return func() error {
+					_, err := GetMappingRepository().Create(ctx, *change.stored)
+					return err
+				}, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, change := range plan.Rules {
+		change := change
+		if change.Action == ActionDelete {
+			err = apply("delete rule "+change.Name, func() (func() error, error) {
+				if err := GetRuleRepository().Delete(ctx, change.stored.ID); err != nil {
+					return nil, err
+				}
+				return func() error {
+					_, err := GetRuleRepository().Create(ctx, *change.stored)
+					return err
+				}, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	plan.Applied = true
+	return nil
+}
+
+// A change made while applying a plan & how to undo it
+type appliedChange struct {
+	description string // e.g. create rule coverage
+	undo        func() error
+}
+
+// Undo the changes newest first, returning the failure with the changes that are still in place
+func undoChanges(applied []appliedChange, cause error) error {
+	applyError := &ApplyError{Err: cause}
+	for i := len(applied) - 1; i >= 0; i-- {
+		if err := applied[i].undo(); err != nil {
+			log.Println("Error undoing", applied[i].description+":", err)
+			applyError.Applied = append([]string{applied[i].description}, applyError.Applied...)
+		}
+	}
+	return applyError
+}
+
+func (plan *PolicyPlan) count(action string) {
+	switch action {
+	case ActionCreate:
+		plan.Creates++
+	case ActionUpdate:
+		plan.Updates++
+	case ActionDelete:
+		plan.Deletes++
+	}
+}
+
+// Check names are unique, mappings refer to rules in the bundle & every rule is valid
+func (bundle PolicyBundle) validate() []FieldError {
+	var fieldErrors []FieldError
+
+	names := make(map[string]bool)
+	for i, rule := range bundle.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		if rule.Name == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".name", Message: "a rule name is required"})
+		} else if names[rule.Name] {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".name", Message: fmt.Sprintf("rule name %s is used more than once", rule.Name)})
+		}
+		names[rule.Name] = true
+
+		for _, fieldError := range rule.rule().validate() {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + "." + fieldError.Field, Message: fieldError.Message})
+		}
+	}
+
+	keys := make(map[string]bool)
+	for i, mapping := range bundle.Mappings {
+		field := fmt.Sprintf("mappings[%d]", i)
+		if !names[mapping.Rule] {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".rule", Message: fmt.Sprintf("no rule named %s in the bundle", mapping.Rule)})
+		}
+		if len(mapping.Environments) == 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".environments", Message: "at least one environment is required"})
+		}
+		if mapping.RuleVersion < 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".ruleVersion", Message: "versions start at 1"})
+		}
+		if keys[mapping.key()] {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf("rule %s is mapped to the same environments more than once", mapping.Rule)})
+		}
+		keys[mapping.key()] = true
+	}
+
+	return fieldErrors
+}
+
+// The bundle form of a stored rule
+func bundleRule(rule ValidationRule) BundleRule {
+	name := rule.Name
+	if name == "" {
+		name = rule.ID.Hex()
+	}
+	return BundleRule{
+		Name:         name,
+		Description:  rule.Description,
+		RuleFamily:   rule.RuleFamily,
+		RuleKey:      rule.RuleKey,
+		RuleLimits:   rule.RuleLimits,
+		Condition:    rule.Condition,
+		Expression:   rule.Expression,
+		Rego:         rule.Rego,
+		Selector:     rule.Selector,
+		Dependencies: rule.Dependencies,
+	}
+}
+
+func (rule BundleRule) rule() ValidationRule {
+	return ValidationRule{
+		Name:         rule.Name,
+		Description:  rule.Description,
+		RuleFamily:   rule.RuleFamily,
+		RuleKey:      rule.RuleKey,
+		RuleLimits:   rule.RuleLimits,
+		Condition:    rule.Condition,
+		Expression:   rule.Expression,
+		Rego:         rule.Rego,
+		Selector:     rule.Selector,
+		Dependencies: rule.Dependencies,
+	}
+}
+
+// The bundle form of a stored mapping, environments mapped as false are left out
+func bundleMapping(mapping ValidationRuleMapping, ruleName string) BundleMapping {
+	bundled := BundleMapping{
+		Rule:         ruleName,
+		Environments: []string{},
+		RuleVersion:  mapping.RuleVersion,
+		Selector:     mapping.Selector,
+	}
+	for environment, enabled := range mapping.Environments {
+		if enabled == true {
+			bundled.Environments = append(bundled.Environments, environment)
+		}
+	}
+	sort.Strings(bundled.Environments)
+	if !mapping.IsEnforced() {
+		bundled.Enforced = mapping.Enforced
+	}
+	return bundled
+}
+
+func (mapping BundleMapping) mapping(ruleID primitive.ObjectID) ValidationRuleMapping {
+	stored := ValidationRuleMapping{
+		RuleId:       ruleID,
+		Environments: make(map[string]interface{}),
+		Enforced:     mapping.Enforced,
+		RuleVersion:  mapping.RuleVersion,
+		Selector:     mapping.Selector,
+	}
+	for _, environment := range mapping.Environments {
+		stored.Environments[environment] = true
+	}
+	return stored
+}
+
+// Identifies the mapping within a bundle, e.g. coverage@preprod,prod
+func (mapping BundleMapping) key() string {
+	environments := append([]string{}, mapping.Environments...)
+	sort.Strings(environments)
+	return mapping.Rule + "@" + strings.Join(environments, ",")
+}
+
+// Sort the environments & drop an explicit enforced: true, so equivalent mappings compare equal
+func (mapping *BundleMapping) normalize() {
+	environments := append([]string{}, mapping.Environments...)
+	sort.Strings(environments)
+	mapping.Environments = environments
+	if mapping.Enforced != nil && *mapping.Enforced {
+		mapping.Enforced = nil
+	}
+}
+
+// Compare two records by their JSON form, which ignores how values were decoded (BSON or JSON)
+func sameJSON(a interface{}, b interface{}) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aJSON) == string(bJSON)
+}
@@ -0,0 +1,58 @@
+package validation
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Compiled expressions & policies kept at once, the least recently used are dropped beyond this
+// Sources sent with one-off requests (rule tests, simulations) are compiled too, so the caches must be bounded
+const compiledCacheSize = 256
+
+// lruCache keeps up to size entries, dropping the least recently used when full
+type lruCache[K comparable, V any] struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[K]*list.Element
+	order   *list.List // most recently used at the front
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{size: size, entries: make(map[K]*list.Element), order: list.New()}
+}
+
+func (cache *lruCache[K, V]) Load(key K) (V, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+func (cache *lruCache[K, V]) Store(key K, value V) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*lruEntry[K, V]).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
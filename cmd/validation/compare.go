@@ -0,0 +1,139 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Plain decimal numbers only, big.Rat would otherwise also accept fractions such as 1/3
+var decimalPattern = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// Compare two decoded values for equality
+// Strings & booleans compare with values of the same type, numbers compare exactly regardless of
+// how they were decoded (int32, int64, float64, Decimal128 or a decimal string)
+func compareEqual(artifactValue interface{}, limitValue interface{}) (bool, error) {
+	aString, aIsString := artifactValue.(string)
+	bString, bIsString := limitValue.(string)
+	if aIsString && bIsString {
+		return aString == bString, nil
+	}
+
+	aBool, aIsBool := artifactValue.(bool)
+	bBool, bIsBool := limitValue.(bool)
+	if aIsBool && bIsBool {
+		return aBool == bBool, nil
+	}
+
+	if !aIsBool && !bIsBool {
+		aNumber, aIsNumber := toNumber(artifactValue)
+		bNumber, bIsNumber := toNumber(limitValue)
+		if aIsNumber && bIsNumber {
+			return aNumber.Cmp(bNumber) == 0, nil
+		}
+	}
+
+	return false, fmt.Errorf("type mismatch: artifact value %v is a %T, which cannot be compared with limit value %v (%T)", artifactValue, artifactValue, limitValue, limitValue)
+}
+
+// Compare two decoded values, values that cannot be compared are not equal
+func equalValues(a interface{}, b interface{}) bool {
+	equal, err := compareEqual(a, b)
+	return err == nil && equal
+}
+
+// Convert a decoded value into an exact rational number
+func toNumber(value interface{}) (*big.Rat, bool) {
+	switch n := value.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int32:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case float32:
+		return floatToNumber(float64(n))
+	case float64:
+		return floatToNumber(n)
+	case json.Number:
+		return decimalToNumber(n.String())
+	case primitive.Decimal128:
+		return decimalToNumber(n.String())
+	case string:
+		return decimalToNumber(n)
+	default:
+		return nil, false
+	}
+}
+
+// Day & week units, which time.ParseDuration doesn't support
+var longDurationPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// Parse a duration such as 30d, 2w, 1d12h or 90m
+func parseDuration(value string) (time.Duration, error) {
+	var err error
+	converted := longDurationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		parts := longDurationPattern.FindStringSubmatch(match)
+		amount, parseErr := strconv.ParseFloat(parts[1], 64)
+		if parseErr != nil {
+			err = parseErr
+			return match
+		}
+		hours := amount * 24
+		if parts[2] == "w" {
+			hours *= 7
+		}
+		return strconv.FormatFloat(hours, 'f', -1, 64) + "h"
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	duration, err := time.ParseDuration(converted)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("duration must not be negative")
+	}
+	return duration, nil
+}
+
+// Convert a decoded value into a time, strings must be RFC 3339 timestamps
+func toTime(value interface{}) (time.Time, bool) {
+	switch t := value.(type) {
+	case time.Time:
+		return t, true
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false
+		}
+		return *t, true
+	case primitive.DateTime:
+		return t.Time(), true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+func floatToNumber(f float64) (*big.Rat, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	return new(big.Rat).SetFloat64(f), true
+}
+
+func decimalToNumber(s string) (*big.Rat, bool) {
+	if !decimalPattern.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
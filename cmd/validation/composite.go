@@ -0,0 +1,168 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	"fmt"
+)
+
+// Condition is the stored form of a composite rule, a tree of limits combined with allOf, anyOf & not
+// Leaves apply their limits to their own key, falling back to the key of the enclosing condition or rule
+//
+//	{ "anyOf": [
+//	    { "key": "artifactMetadata.coverage", "limits": [{ "type": "min", "value": 80 }] },
+//	    { "key": "artifactType", "limits": [{ "type": "equal", "value": "docs" }] }
+//	] }
+type Condition struct {
+	Key        string      `json:"key,omitempty" bson:"key,omitempty"`
+	Limits     []RuleLimit `json:"limits,omitempty" bson:"limits,omitempty"` // all of the limits must pass
+	AllOf      []Condition `json:"allOf,omitempty" bson:"allOf,omitempty"`
+	AnyOf      []Condition `json:"anyOf,omitempty" bson:"anyOf,omitempty"`
+	Not        *Condition  `json:"not,omitempty" bson:"not,omitempty"`
+	Expression Expression  `json:"expression,omitempty" bson:"expression,omitempty"` // CEL, addresses the whole artifact
+}
+
+// compile-time interface checks
+var _ Constraint = Condition{}
+var _ Constraint = AllOf{}
+var _ Constraint = AnyOf{}
+var _ Constraint = Not{}
+var _ Constraint = Keyed{}
+
+// Evaluate implements Constraint, every part set on the condition must pass
+func (condition Condition) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	return condition.Constraint().Evaluate(artifact, ruleKey)
+}
+
+// Build the constraint tree for the condition
+func (condition Condition) Constraint() Constraint {
+	var parts AllOf
+
+	for _, lim := range condition.Limits {
+		parts = append(parts, lim)
+	}
+	if len(condition.AllOf) > 0 {
+		group := AllOf{}
+		for _, child := range condition.AllOf {
+			group = append(group, child)
+		}
+		parts = append(parts, group)
+	}
+	if len(condition.AnyOf) > 0 {
+		group := AnyOf{}
+		for _, child := range condition.AnyOf {
+			group = append(group, child)
+		}
+		parts = append(parts, group)
+	}
+	if condition.Not != nil {
+		parts = append(parts, Not{Constraint: *condition.Not})
+	}
+	if condition.Expression != "" {
+		parts = append(parts, condition.Expression)
+	}
+
+	if condition.Key == "" {
+		return parts
+	}
+	return Keyed{Key: condition.Key, Constraint: parts}
+}
+
+// AllOf passes when every constraint passes, an empty AllOf is an invalid condition
+type AllOf []Constraint
+
+// Evaluate implements Constraint
+func (group AllOf) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	if len(group) == 0 {
+		return violation([]string{"empty condition, expected limits, allOf, anyOf, not or expression"})
+	}
+
+	var problems []string
+	for _, constraint := range group {
+		if err := constraint.Evaluate(artifact, ruleKey); err != nil {
+			problems = append(problems, err.Problems...)
+		}
+	}
+	return violation(problems)
+}
+
+// AnyOf passes when at least one of the constraints passes
+type AnyOf []Constraint
+
+// Evaluate implements Constraint
+func (group AnyOf) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	if len(group) == 0 {
+		return violation([]string{"empty anyOf condition"})
+	}
+
+	var problems []string
+	for i, constraint := range group {
+		err := constraint.Evaluate(artifact, ruleKey)
+		if err == nil {
+			return nil
+		}
+		for _, problem := range err.Problems {
+			problems = append(problems, fmt.Sprintf("anyOf[%d]: %s", i, problem))
+		}
+	}
+	return violation(append([]string{"none of the anyOf conditions passed"}, problems...))
+}
+
+// Not passes when the constraint fails
+type Not struct {
+	Constraint Constraint
+}
+
+// Evaluate implements Constraint
+func (not Not) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	if not.Constraint.Evaluate(artifact, ruleKey) == nil {
+		return violation([]string{fmt.Sprintf("not: condition %s passed but must fail", describeConstraint(not.Constraint, ruleKey))})
+	}
+	return nil
+}
+
+// Keyed evaluates a constraint against its own key rather than the rule's
+type Keyed struct {
+	Key        string
+	Constraint Constraint
+}
+
+// Evaluate implements Constraint
+func (keyed Keyed) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	return keyed.Constraint.Evaluate(artifact, keyed.Key)
+}
+
+// Short description of a constraint for violation messages
+func describeConstraint(constraint Constraint, ruleKey string) string {
+	switch c := constraint.(type) {
+	case RuleLimit:
+		return fmt.Sprintf("%s %s %v", ruleKey, c.Type, describeValue(c.Value))
+	case Condition:
+		return describeConstraint(c.Constraint(), ruleKey)
+	case Keyed:
+		return describeConstraint(c.Constraint, c.Key)
+	case Not:
+		return fmt.Sprintf("not(%s)", describeConstraint(c.Constraint, ruleKey))
+	case Expression:
+		return string(c)
+	case AllOf:
+		return describeGroup("allOf", c, ruleKey)
+	case AnyOf:
+		return describeGroup("anyOf", c, ruleKey)
+	default:
+		return fmt.Sprintf("%v", constraint)
+	}
+}
+
+func describeGroup(name string, constraints []Constraint, ruleKey string) string {
+	if len(constraints) == 1 {
+		return describeConstraint(constraints[0], ruleKey)
+	}
+	description := name + "("
+	for i, constraint := range constraints {
+		if i > 0 {
+			description += ", "
+		}
+		description += describeConstraint(constraint, ruleKey)
+	}
+	return description + ")"
+}
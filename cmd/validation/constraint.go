@@ -2,7 +2,7 @@ package validation
 
 import (
 	artifacts "artifactflow.com/m/v2/cmd/artifacts"
-	"fmt"
+	"strings"
 )
 
 type Constraint interface {
@@ -19,5 +19,5 @@ var _ error = ConstraintViolation{}
 
 // Error implements error
 func (cv ConstraintViolation) Error() string {
-	return fmt.Sprintf("%v", cv.Problems)
+	return strings.Join(cv.Problems, "; ")
 }
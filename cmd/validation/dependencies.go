@@ -0,0 +1,187 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// What a dependency rule requires of each of the artifact's dependencies in the environment
+const (
+	RequireValidated = "validated" // promoted to the environment, or passes its rules (default)
+	RequirePromoted  = "promoted"  // already promoted to the environment
+)
+
+// Artifacts one walk of the dependency graph reads at most, dependencies beyond it fail the rule
+const maxDependencyArtifacts = 100
+
+// DependencyRequirement is a rule type checking the artifacts listed in the artifact's dependencies
+//
+//	{ "dependencies": { "require": "validated" } }
+type DependencyRequirement struct {
+	Require string `json:"require,omitempty" bson:"require,omitempty"` // validated / promoted, unset is validated
+}
+
+// DependencyPolicy evaluates an artifact's dependencies against an environment, each dependency's own
+// dependencies are followed through the rules that apply to it, so the whole graph is checked
+type DependencyPolicy struct {
+	Requirement DependencyRequirement
+	Environment string
+	graph       *dependencyGraph // shared while walking the graph, unset starts a new walk at the artifact
+}
+
+// compile-time interface check
+var _ Constraint = DependencyPolicy{}
+
+// Evaluate implements Constraint, every failing dependency is a problem
+func (policy DependencyPolicy) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	var problems []string
+	for _, check := range policy.Check(artifact) {
+		if !check.Passed {
+			problems = append(problems, check.Message)
+		}
+	}
+	if len(problems) > 0 {
+		return &ConstraintViolation{Problems: problems}
+	}
+	return nil
+}
+
+// Check each dependency separately, so the report shows which dependency caused the failure
+func (policy DependencyPolicy) Check(artifact artifacts.Artifact) []CheckResult {
+	return policy.check(context.Background(), artifact)
+}
+
+// Check the dependencies within the request, a new walk of the graph reads the artifacts & rules with its context
+func (policy DependencyPolicy) check(ctx context.Context, artifact artifacts.Artifact) []CheckResult {
+	graph := policy.graph
+	if graph == nil {
+		graph = newDependencyGraph(ctx, policy.Environment)
+	}
+
+	// The artifact is on the path while its dependencies are checked, so a dependency leading back to it is a cycle
+	graph.path = append(graph.path, artifact.ID.Hex())
+	defer func() { graph.path = graph.path[:len(graph.path)-1] }()
+
+	checks := []CheckResult{}
+	for _, dependency := range artifact.Dependencies {
+		status, problem := graph.check(dependency, policy.Requirement.require())
+		check := CheckResult{Key: dependency, LimitType: "dependency", Expected: policy.Requirement.require(), Actual: status, Passed: problem == ""}
+		check.Message = problem
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func (requirement DependencyRequirement) require() string {
+	if requirement.Require == "" {
+		return RequireValidated
+	}
+	return requirement.Require
+}
+
+// --------------------------------------------
+// Dependency Graph
+// --------------------------------------------
+
+// The state of one walk of the dependency graph in an environment
+type dependencyGraph struct {
+	ctx         context.Context // the request the walk is made for
+	environment string
+	visited     int                          // artifacts read, up to maxDependencyArtifacts
+	path        []string                     // artifact IDs from the artifact being validated to the current dependency
+	rules       []mappedRule                 // the rules mapped to the environment, loaded on first use
+	outcomes    map[string]dependencyOutcome // dependencies already checked, keyed by artifact ID & requirement
+}
+
+type dependencyOutcome struct {
+	status  string // promoted / passed / failed / cycle / missing / limit / error
+	problem string // why the dependency failed, unset when it passed
+}
+
+func newDependencyGraph(ctx context.Context, environment string) *dependencyGraph {
+	return &dependencyGraph{ctx: ctx, environment: environment, outcomes: make(map[string]dependencyOutcome)}
+}
+
+// Check one dependency, returning its status & the problem when it fails the requirement
+func (graph *dependencyGraph) check(dependency string, require string) (string, string) {
+	for i, id := range graph.path {
+		if id == dependency {
+			cycle := append(append([]string{}, graph.path[i:]...), dependency)
+			return "cycle", fmt.Sprintf("dependency cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	key := dependency + "/" + require
+	if outcome, ok := graph.outcomes[key]; ok {
+		return outcome.status, outcome.problem
+	}
+
+	if graph.visited >= maxDependencyArtifacts {
+		return "limit", fmt.Sprintf("dependency %s not checked, the dependency graph has more than %d artifacts", dependency, maxDependencyArtifacts)
+	}
+	graph.visited++
+
+	outcome := graph.evaluate(dependency, require)
+	graph.outcomes[key] = outcome
+	return outcome.status, outcome.problem
+}
+
+func (graph *dependencyGraph) evaluate(dependency string, require string) dependencyOutcome {
+	id, err := primitive.ObjectIDFromHex(dependency)
+	if err != nil {
+		return dependencyOutcome{status: "missing", problem: fmt.Sprintf("dependency %s is not a valid artifact ID", dependency)}
+	}
+	artifact, err := artifacts.GetRepository().Get(graph.ctx, id)
+	if err == database.ErrNotFound {
+		return dependencyOutcome{status: "missing", problem: fmt.Sprintf("dependency %s not found", dependency)}
+	}
+	if err != nil {
+		return dependencyOutcome{status: "error", problem: fmt.Sprintf("unable to retrieve dependency %s: %v", dependency, err)}
+	}
+
+	if artifact.LatestPromotion(graph.environment) != nil {
+		return dependencyOutcome{status: "promoted"}
+	}
+	if require == RequirePromoted {
+		return dependencyOutcome{status: "failed", problem: fmt.Sprintf("dependency %s has not been promoted to %s", describeArtifact(*artifact), graph.environment)}
+	}
+
+	if graph.rules == nil {
+		rules, err := loadMappedRules(graph.ctx, graph.environment, make(ruleCache))
+		if err != nil {
+			return dependencyOutcome{status: "error", problem: fmt.Sprintf("unable to retrieve the validation rules for dependency %s: %v", dependency, err)}
+		}
+		graph.rules = rules
+	}
+
+	// Only failures that would fail the dependency's own validation count, warnings & waived failures don't
+	var failures []string
+	for _, result := range validateAgainstRules(graph.ctx, artifact, graph.environment, applicableRules(*artifact, graph.rules), graph) {
+		if !result.Passed && !result.Waived && result.Enforced {
+			failures = append(failures, fmt.Sprintf("%s (%s)", result.Message, ruleResultName(result)))
+		}
+	}
+	if len(failures) > 0 {
+		return dependencyOutcome{status: "failed", problem: fmt.Sprintf("dependency %s does not pass validation for %s: %s", describeArtifact(*artifact), graph.environment, strings.Join(failures, "; "))}
+	}
+	return dependencyOutcome{status: "passed"}
+}
+
+// The artifact's ID, with its name when it has one
+func describeArtifact(artifact artifacts.Artifact) string {
+	if artifact.Name != "" {
+		return fmt.Sprintf("%s (%s)", artifact.ID.Hex(), artifact.Name)
+	}
+	return artifact.ID.Hex()
+}
+
+func ruleResultName(result RuleResult) string {
+	if result.Name != "" {
+		return result.Name
+	}
+	return result.RuleId
+}
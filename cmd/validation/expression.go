@@ -0,0 +1,158 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"sync"
+)
+
+// Expression is a Common Expression Language (CEL) expression evaluated against the whole artifact, it passes when it evaluates to true
+// The artifact's fields are available as variables: id, name, description, artifactType, artifactFamily, artifactMetadata, dependencies, createdAt & updatedAt
+//
+//	artifactType == "docs" || artifactMetadata.coverage >= 80
+type Expression string
+
+// compile-time interface check
+var _ Constraint = Expression("")
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+
+	// Compiled programs, keyed by expression source
+	celPrograms = newLRUCache[Expression, cel.Program](compiledCacheSize)
+)
+
+func expressionEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.Variable("id", cel.StringType),
+			cel.Variable("name", cel.StringType),
+			cel.Variable("description", cel.StringType),
+			cel.Variable("artifactType", cel.StringType),
+			cel.Variable("artifactFamily", cel.StringType),
+			cel.Variable("artifactMetadata", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("dependencies", cel.ListType(cel.StringType)),
+			cel.Variable("createdAt", cel.TimestampType),
+			cel.Variable("updatedAt", cel.TimestampType),
+			cel.CrossTypeNumericComparisons(true),
+		)
+	})
+	return celEnv, celEnvErr
+}
+
+// Compile & type check the expression, it must evaluate to a boolean
+func (expression Expression) Compile() (cel.Program, error) {
+	if program, ok := celPrograms.Load(expression); ok {
+		return program, nil
+	}
+
+	env, err := expressionEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(string(expression))
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	// dyn results (e.g. artifactMetadata.signed) can only be checked when evaluated
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	celPrograms.Store(expression, program)
+	return program, nil
+}
+
+// Evaluate implements Constraint, the rule key is not used as the expression addresses the artifact itself
+func (expression Expression) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	program, err := expression.Compile()
+	if err != nil {
+		return violation([]string{fmt.Sprintf("invalid expression %s: %v", expression, err)})
+	}
+
+	out, _, err := program.Eval(expressionVariables(artifact))
+	if err != nil {
+		return violation([]string{fmt.Sprintf("expression %s failed: %v", expression, err)})
+	}
+
+	passed, ok := out.Value().(bool)
+	if !ok {
+		return violation([]string{fmt.Sprintf("expression %s returned %v, expected a bool", expression, out.Value())})
+	}
+	if !passed {
+		return violation([]string{fmt.Sprintf("expression %s is false", expression)})
+	}
+	return nil
+}
+
+func expressionVariables(artifact artifacts.Artifact) map[string]any {
+	variables := map[string]any{
+		"id":               artifact.ID.Hex(),
+		"name":             artifact.Name,
+		"description":      artifact.Description,
+		"artifactType":     artifact.ArtifactType,
+		"artifactFamily":   artifact.ArtifactFamily,
+		"artifactMetadata": expressionValue(artifact.ArtifactMetadata),
+		"dependencies":     append([]string{}, artifact.Dependencies...), // empty rather than unset, so size(dependencies) works
+	}
+	if artifact.CreatedAt != nil {
+		variables["createdAt"] = *artifact.CreatedAt
+	}
+	if artifact.UpdatedAt != nil {
+		variables["updatedAt"] = *artifact.UpdatedAt
+	}
+	return variables
+}
+
+// Convert metadata read back from MongoDB into plain values CEL understands
+func expressionValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for key, child := range v {
+			converted[key] = expressionValue(child)
+		}
+		return converted
+	case primitive.M:
+		return expressionValue(map[string]any(v))
+	case primitive.D:
+		converted := make(map[string]any, len(v))
+		for _, element := range v {
+			converted[element.Key] = expressionValue(element.Value)
+		}
+		return converted
+	case []any:
+		converted := make([]any, len(v))
+		for i, child := range v {
+			converted[i] = expressionValue(child)
+		}
+		return converted
+	case primitive.A:
+		return expressionValue([]any(v))
+	case int32:
+		return int64(v)
+	case primitive.DateTime:
+		return v.Time()
+	case primitive.Decimal128:
+		if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return f
+		}
+		return v.String()
+	case primitive.ObjectID:
+		return v.Hex()
+	default:
+		return v
+	}
+}
@@ -2,9 +2,15 @@ package validation
 
 import (
 	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	"encoding/json"
 	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	//"log"
-	"strings"
+	"math/big"
+	"regexp"
+	"time"
 )
 
 //type Limit struct {
@@ -19,113 +25,408 @@ var _ Constraint = RuleLimit{}
 //	return fmt.Sprintf("type: %s, value: %s", lim.Type, lim.Value)
 //}
 
-// Evaluate implements Constraint
+// Limit values read back from the database hold BSON types (primitive.D, primitive.A), which are written out as plain JSON
+func (lim RuleLimit) MarshalJSON() ([]byte, error) {
+	type plainLimit RuleLimit
+	plain := plainLimit(lim)
+	if lim.Value != nil {
+		value := expressionValue(*lim.Value)
+		plain.Value = &value
+	}
+	return json.Marshal(plain)
+}
+
+// Evaluate implements Constraint, returning nil when the artifact is within the limit
 func (lim RuleLimit) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	value, found := resolveKey(artifact, ruleKey)
+	return lim.evaluateValue(value, found, ruleKey)
+}
+
+// Check a value resolved from the artifact, found is false when the key was not present
+func (lim RuleLimit) evaluateValue(value any, found bool, ruleKey string) *ConstraintViolation {
 	switch lim.Type {
-	case "min", "max", "equal", "set":
-		return lim.checkLimitValues(artifact, ruleKey)
+	case "min", "max", "equal":
+		return lim.checkLimitValues(value, found, ruleKey)
+	case "notEqual", "in", "notIn":
+		return lim.checkMembership(value, found, ruleKey)
+	case "matches":
+		return lim.checkPattern(value, found, ruleKey)
+	case "set", "exists", "absent":
+		return lim.checkPresence(value, found, ruleKey)
+	case "semverMin", "semverMax", "semverRange":
+		return lim.checkVersion(value, found, ruleKey)
+	case "olderThan", "newerThan", "before", "after":
+		return lim.checkTime(value, found, ruleKey)
+	case "count", "any", "all", "none":
+		return lim.checkCollection(value, found, ruleKey)
 	default:
 		return &ConstraintViolation{
 			//Key:      ruleKey,
-			Problems: []string{fmt.Sprintf("Unsupported Limit Type, set to %T with value %d, supported values are one of equal|min|max|set", lim.Type, *lim.Value)},
+			Problems: []string{fmt.Sprintf("Unsupported Limit Type, set to %s, supported values are one of equal|notEqual|min|max|in|notIn|matches|set|exists|absent|semverMin|semverMax|semverRange|olderThan|newerThan|before|after|count|any|all|none", lim.Type)},
 		}
 	}
 }
 
-func (lim RuleLimit) checkLimitValues(artifact artifacts.Artifact, ruleKey string) (err *ConstraintViolation) {
-	fmt.Println("Info: checking contents", artifact, "for limit", ruleKey, "of type", lim.Type, "with max", lim.Value)
-	problems := make([]string, 0, 1)
-	defer func() {
-		if len(problems) == 0 {
-			err = nil
+// Turn a list of problems into a violation, nil when there are none
+func violation(problems []string) *ConstraintViolation {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ConstraintViolation{Problems: problems}
+}
+
+// set & exists: the key must be present, absent: the key must not be present
+func (lim RuleLimit) checkPresence(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Type == "absent" && found {
+		return violation([]string{fmt.Sprintf("key %s is set but must be absent", ruleKey)})
+	}
+	if lim.Type != "absent" && !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+	return nil
+}
+
+// notEqual: the value must differ from the limit value, in/notIn: the value must (not) be one of a list of values
+func (lim RuleLimit) checkMembership(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+
+	candidates := []interface{}{*lim.Value}
+	if lim.Type != "notEqual" {
+		list, ok := limitList(*lim.Value)
+		if !ok {
+			return violation([]string{fmt.Sprintf("limit type %s requires a list of values, got %T", lim.Type, *lim.Value)})
 		}
-		err = &ConstraintViolation{
-			//Key:      ruleKey,
-			Problems: problems,
+		candidates = list
+	}
+
+	member := false
+	for _, candidate := range candidates {
+		if equalValues(value, candidate) {
+			member = true
+			break
 		}
-	}()
+	}
 
-	keys := strings.Split(ruleKey, ".")
+	switch {
+	case lim.Type == "notEqual" && member:
+		return violation([]string{fmt.Sprintf("%v must not be equal to %v", value, *lim.Value)})
+	case lim.Type == "in" && !member:
+		return violation([]string{fmt.Sprintf("%v is not one of %v", value, candidates)})
+	case lim.Type == "notIn" && member:
+		return violation([]string{fmt.Sprintf("%v must not be one of %v", value, candidates)})
+	}
+	return nil
+}
 
-	//fmt.Println("Checking where the keys are ", keys, "within", artifact, "for", ruleKey)
-	// Is the key within the infinitely nested map object?
+// matches: the value must be a string matching the limit's regular expression
+func (lim RuleLimit) checkPattern(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	pattern, ok := (*lim.Value).(string)
+	if !ok {
+		return violation([]string{fmt.Sprintf("limit type matches requires a regular expression string, got %T", *lim.Value)})
+	}
+
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return violation([]string{fmt.Sprintf("invalid regular expression %s: %v", pattern, err)})
+	}
+
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return violation([]string{fmt.Sprintf("artifact value %v is a %T, matches can only be applied to strings", value, value)})
+	}
+
+	if !expression.MatchString(str) {
+		return violation([]string{fmt.Sprintf("%s does not match %s", str, pattern)})
+	}
+	return nil
+}
+
+// semverMin/semverMax: the value must be a semantic version at least/at most the limit version
+// semverRange: the value must satisfy a version constraint such as ">=1.20.0 <2.0.0"
+func (lim RuleLimit) checkVersion(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	limitValue, ok := (*lim.Value).(string)
+	if !ok {
+		return violation([]string{fmt.Sprintf("limit type %s requires a version string, got %T", lim.Type, *lim.Value)})
+	}
+
+	constraint, err := lim.versionConstraint(limitValue)
+	if err != nil {
+		return violation([]string{fmt.Sprintf("invalid version limit %s: %v", limitValue, err)})
+	}
 
-	value, ok := lookup(artifact, artifact.ArtifactMetadata, keys)
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+
+	str, ok := value.(string)
 	if !ok {
-		problems = append(problems, fmt.Sprintf("specified key not found: %v", keys))
-		return
-	}
-
-	// Variable Setup to Handle Cases
-	var intArtifactValue int
-	var strArtifactValue string
-	var intLimitValue int
-	var strLimitValue string
-
-	switch artifactValue := (value).(type) {
-	case string:
-		strArtifactValue = artifactValue
-	case int, int32, float64:
-		//fmt.Println("Artifact value found to be:", artifactValue)
-		if n, ok := artifactValue.(int); ok {
-			//fmt.Println("Setting intArtifactValue from int", n)
-			intArtifactValue = n
-		} else if f64, ok := artifactValue.(float64); ok {
-			//fmt.Println("Setting intArtifactValue from float64", f64)
-			intArtifactValue = int(f64)
-		} else if i32, ok := artifactValue.(int32); ok {
-			//fmt.Println("Setting intArtifactValue from int32", i32)
-			intArtifactValue = int(i32)
-		} else {
-			problems = append(problems, fmt.Sprintf("artifact value is not an acceptable int: %v", artifactValue))
-			return
+		return violation([]string{fmt.Sprintf("type mismatch: artifact value %v is a %T, %s requires a version string", value, value, lim.Type)})
+	}
+
+	version, err := semver.NewVersion(str)
+	if err != nil {
+		return violation([]string{fmt.Sprintf("artifact value %s is not a semantic version: %v", str, err)})
+	}
+
+	if !constraint.Check(version) {
+		switch lim.Type {
+		case "semverMin":
+			return violation([]string{fmt.Sprintf("version %s is lower than %s", str, limitValue)})
+		case "semverMax":
+			return violation([]string{fmt.Sprintf("version %s is higher than %s", str, limitValue)})
+		default:
+			return violation([]string{fmt.Sprintf("version %s is not within %s", str, limitValue)})
 		}
+	}
+	return nil
+}
+
+// Build the version constraint for a semverMin, semverMax or semverRange limit value
+func (lim RuleLimit) versionConstraint(limitValue string) (*semver.Constraints, error) {
+	switch lim.Type {
+	case "semverMin":
+		return semver.NewConstraint(">= " + limitValue)
+	case "semverMax":
+		return semver.NewConstraint("<= " + limitValue)
 	default:
-		problems = append(problems, fmt.Sprintf("Unsupported Artifact Value type, set to %T with value %d", artifactValue, artifactValue))
-		return
-	}
-
-	//fmt.Println("limValue is set to", *lim.Value)
-	// Need something in here that checks the two variables are of the same type, otherwise they can't be compared
-	switch limValue := (*lim.Value).(type) {
-	case string:
-		strLimitValue = limValue
-		if lim.Type == "equal" && strArtifactValue != strLimitValue {
-			problems = append(problems, fmt.Sprintf("%s is not equal to %s", strArtifactValue, strLimitValue))
-		}
-	case int, int32, float64:
-		//fmt.Println("Limit value found to be:", limValue)
-		if n, ok := limValue.(int); ok {
-			//fmt.Println("Setting intLimValue from int", n)
-			intLimitValue = n
-		} else if f64, ok := limValue.(float64); ok {
-			//fmt.Println("Setting intLimValue from float64", f64)
-			intLimitValue = int(f64)
-		} else if i32, ok := limValue.(int32); ok {
-			//fmt.Println("Setting intLimValue from int32", i32)
-			intLimitValue = int(i32)
-		} else {
-			problems = append(problems, fmt.Sprintf("value is not an int: %v", limValue))
-			return
-		}
-		// limit     record
-		//fmt.Println(intLimitValue, intArtifactValue)
-		//log.Printf("The type of intLimValue is %T with limit type %d", intLimitValue, lim.Type)
-		//log.Printf("The type of intArtifactValue is %T", intArtifactValue)
-		// min / max / equal / set (set doesn't need a case, would've errored earlier if it didn't exist)
-
-		if lim.Type == "equal" && intArtifactValue != intLimitValue {
-			problems = append(problems, fmt.Sprintf("%d is not equal to %d", intArtifactValue, intLimitValue))
-		} else if lim.Type == "min" && lim.Value != nil && intArtifactValue < intLimitValue {
-			problems = append(problems, fmt.Sprintf("%d is less than %d", intArtifactValue, intLimitValue))
-		} else if lim.Type == "max" && lim.Value != nil && intArtifactValue > intLimitValue {
-			problems = append(problems, fmt.Sprintf("%d is greater than %d", intArtifactValue, intLimitValue))
-		} 
+		return semver.NewConstraint(limitValue)
+	}
+}
 
+// olderThan/newerThan: the value must be a timestamp older/newer than a duration ago (e.g. 30d, 12h)
+// before/after: the value must be a timestamp before/after an RFC 3339 timestamp
+func (lim RuleLimit) checkTime(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	var threshold time.Time
+	switch lim.Type {
+	case "olderThan", "newerThan":
+		durationValue, ok := (*lim.Value).(string)
+		if !ok {
+			return violation([]string{fmt.Sprintf("limit type %s requires a duration such as 30d or 12h, got %T", lim.Type, *lim.Value)})
+		}
+		duration, err := parseDuration(durationValue)
+		if err != nil {
+			return violation([]string{fmt.Sprintf("invalid duration %s: %v", durationValue, err)})
+		}
+		threshold = time.Now().Add(-duration)
 	default:
-		problems = append(problems, fmt.Sprintf("Unsupported Limit Value type, set to %T with value %d", *lim.Value, *lim.Value))
-		return
+		limitTime, ok := toTime(*lim.Value)
+		if !ok {
+			return violation([]string{fmt.Sprintf("limit type %s requires an RFC 3339 timestamp, got %v", lim.Type, *lim.Value)})
+		}
+		threshold = limitTime
+	}
+
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
 	}
 
-	return
-}
\ No newline at end of file
+	timestamp, ok := toTime(value)
+	if !ok {
+		return violation([]string{fmt.Sprintf("type mismatch: artifact value %v is not an RFC 3339 timestamp", value)})
+	}
+
+	switch lim.Type {
+	case "olderThan":
+		if !timestamp.Before(threshold) {
+			return violation([]string{fmt.Sprintf("%s is not older than %v", timestamp.Format(time.RFC3339), *lim.Value)})
+		}
+	case "newerThan":
+		if !timestamp.After(threshold) {
+			return violation([]string{fmt.Sprintf("%s is not newer than %v", timestamp.Format(time.RFC3339), *lim.Value)})
+		}
+	case "before":
+		if !timestamp.Before(threshold) {
+			return violation([]string{fmt.Sprintf("%s is not before %s", timestamp.Format(time.RFC3339), threshold.Format(time.RFC3339))})
+		}
+	case "after":
+		if !timestamp.After(threshold) {
+			return violation([]string{fmt.Sprintf("%s is not after %s", timestamp.Format(time.RFC3339), threshold.Format(time.RFC3339))})
+		}
+	}
+	return nil
+}
+
+// Value of a count limit, e.g. { "where": { "type": "equal", "value": "HIGH" }, "max": 3 }
+type countLimit struct {
+	Where *RuleLimit   `bson:"where,omitempty"`
+	Min   *interface{} `bson:"min,omitempty"`
+	Max   *interface{} `bson:"max,omitempty"`
+}
+
+// any/all/none: at least one/every/no element of the list at the key must pass the nested limit given as the value
+// count: the number of elements (passing the optional nested "where" limit) must be within "min" & "max", a plain number must match exactly
+func (lim RuleLimit) checkCollection(value any, found bool, ruleKey string) *ConstraintViolation {
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	var nested *RuleLimit
+	var limits countLimit
+	if lim.Type == "count" {
+		if _, ok := toNumber(*lim.Value); ok {
+			limits.Min, limits.Max = lim.Value, lim.Value
+		} else if err := decodeLimitValue(*lim.Value, &limits); err != nil {
+			return violation([]string{fmt.Sprintf("limit type count requires a number or an object with where/min/max, got %v", *lim.Value)})
+		}
+		nested = limits.Where
+	} else {
+		nested = &RuleLimit{}
+		if err := decodeLimitValue(*lim.Value, nested); err != nil || nested.Type == "" {
+			return violation([]string{fmt.Sprintf("limit type %s requires a nested limit such as { \"type\": \"equal\", \"value\": \"CRITICAL\" }, got %v", lim.Type, *lim.Value)})
+		}
+	}
+
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+
+	elements, ok := limitList(value)
+	if !ok {
+		return violation([]string{fmt.Sprintf("type mismatch: artifact value %v is a %T, %s requires a list (use [*] in the key to collect values)", value, value, lim.Type)})
+	}
+
+	matched := 0
+	var failures []string
+	for i, element := range elements {
+		if nested == nil {
+			matched++
+			continue
+		}
+		if err := nested.evaluateValue(element, true, fmt.Sprintf("%s[%d]", ruleKey, i)); err != nil {
+			failures = append(failures, fmt.Sprintf("element %d: %s", i, err.Error()))
+			continue
+		}
+		matched++
+	}
+
+	switch lim.Type {
+	case "any":
+		if matched == 0 {
+			return violation([]string{fmt.Sprintf("no element of %s passes %s %v", ruleKey, nested.Type, describeValue(nested.Value))})
+		}
+	case "all":
+		return violation(failures)
+	case "none":
+		if matched > 0 {
+			return violation([]string{fmt.Sprintf("%d element(s) of %s pass %s %v", matched, ruleKey, nested.Type, describeValue(nested.Value))})
+		}
+	case "count":
+		var problems []string
+		for _, bound := range []struct {
+			name  string
+			value *interface{}
+		}{{"min", limits.Min}, {"max", limits.Max}} {
+			if bound.value == nil {
+				continue
+			}
+			number, ok := toNumber(*bound.value)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("type mismatch: count %s %v is a %T, it requires a number", bound.name, *bound.value, *bound.value))
+				continue
+			}
+			comparison := new(big.Rat).SetInt64(int64(matched)).Cmp(number)
+			if bound.name == "min" && comparison < 0 {
+				problems = append(problems, fmt.Sprintf("count of %s is %d, less than %v", ruleKey, matched, *bound.value))
+			}
+			if bound.name == "max" && comparison > 0 {
+				problems = append(problems, fmt.Sprintf("count of %s is %d, greater than %v", ruleKey, matched, *bound.value))
+			}
+		}
+		return violation(problems)
+	}
+	return nil
+}
+
+// Nested limit values are decoded from JSON (map[string]interface{}) or BSON (primitive.D)
+func decodeLimitValue(value interface{}, target interface{}) error {
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, target)
+}
+
+func describeValue(value *interface{}) interface{} {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// Limit values are decoded from JSON ([]interface{}) or BSON (primitive.A)
+func limitList(value interface{}) ([]interface{}, bool) {
+	switch list := value.(type) {
+	case []interface{}:
+		return list, true
+	case primitive.A:
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+func (lim RuleLimit) checkLimitValues(value any, found bool, ruleKey string) *ConstraintViolation {
+	fmt.Println("Info: checking contents", value, "for limit", ruleKey, "of type", lim.Type, "with value", lim.Value)
+
+	if lim.Value == nil {
+		return violation([]string{fmt.Sprintf("limit type %s requires a value", lim.Type)})
+	}
+
+	// Is the key within the infinitely nested map object?
+	if !found {
+		return violation([]string{fmt.Sprintf("specified key not found: %s", ruleKey)})
+	}
+
+	limitValue := *lim.Value
+
+	switch lim.Type {
+	case "equal":
+		equal, err := compareEqual(value, limitValue)
+		if err != nil {
+			return violation([]string{err.Error()})
+		}
+		if !equal {
+			return violation([]string{fmt.Sprintf("%v is not equal to %v", value, limitValue)})
+		}
+	case "min", "max":
+		artifactNumber, ok := toNumber(value)
+		if !ok {
+			return violation([]string{fmt.Sprintf("type mismatch: artifact value %v is a %T, %s requires a number", value, value, lim.Type)})
+		}
+		limitNumber, ok := toNumber(limitValue)
+		if !ok {
+			return violation([]string{fmt.Sprintf("type mismatch: limit value %v is a %T, %s requires a number", limitValue, limitValue, lim.Type)})
+		}
+
+		if lim.Type == "min" && artifactNumber.Cmp(limitNumber) < 0 {
+			return violation([]string{fmt.Sprintf("%v is less than %v", value, limitValue)})
+		}
+		if lim.Type == "max" && artifactNumber.Cmp(limitNumber) > 0 {
+			return violation([]string{fmt.Sprintf("%v is greater than %v", value, limitValue)})
+		}
+	}
+
+	return nil
+}
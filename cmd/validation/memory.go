@@ -0,0 +1,288 @@
+package validation
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// --------------------------------------------
+// Validation Rules
+// --------------------------------------------
+
+// MemoryRuleRepository keeps Validation Rules in process memory, used with DB_BACKEND=memory
+type MemoryRuleRepository struct {
+	collection *database.MemoryCollection[ValidationRule]
+}
+
+// compile-time interface check
+var _ RuleRepository = &MemoryRuleRepository{}
+
+func NewMemoryRuleRepository() *MemoryRuleRepository {
+	return &MemoryRuleRepository{
+		collection: database.NewMemoryCollection[ValidationRule](),
+	}
+}
+
+func (repo *MemoryRuleRepository) Create(ctx context.Context, rule ValidationRule) (primitive.ObjectID, error) {
+	if rule.ID.IsZero() {
+		rule.ID = primitive.NewObjectID()
+	}
+	return rule.ID, repo.collection.Insert(rule.ID, rule)
+}
+
+func (repo *MemoryRuleRepository) List(ctx context.Context) ([]ValidationRule, error) {
+	return repo.collection.Find(nil)
+}
+
+func (repo *MemoryRuleRepository) Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRule, error) {
+	return searchMemory(repo.collection, filter)
+}
+
+func (repo *MemoryRuleRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRule, error) {
+	return repo.collection.Get(id)
+}
+
+func (repo *MemoryRuleRepository) Update(ctx context.Context, id primitive.ObjectID, version int, rule ValidationRule) error {
+	match := func(stored ValidationRule) bool { return stored.Version == version }
+	return repo.collection.UpdateIf(id, match, func(stored *ValidationRule) {
+		stored.Name = rule.Name
+		stored.Description = rule.Description
+		stored.RuleFamily = rule.RuleFamily
+		stored.RuleKey = rule.RuleKey
+		stored.RuleLimits = rule.RuleLimits
+		stored.Condition = rule.Condition
+		stored.Expression = rule.Expression
+		stored.Rego = rule.Rego
+		stored.Selector = rule.Selector
+		stored.Dependencies = rule.Dependencies
+		stored.Version = rule.Version
+	})
+}
+
+func (repo *MemoryRuleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return repo.collection.Delete(id)
+}
+
+// --------------------------------------------
+// Validation Rule Mappings
+// --------------------------------------------
+
+// MemoryMappingRepository keeps Validation Rule Mappings in process memory, used with DB_BACKEND=memory
+type MemoryMappingRepository struct {
+	collection *database.MemoryCollection[ValidationRuleMapping]
+}
+
+// compile-time interface check
+var _ MappingRepository = &MemoryMappingRepository{}
+
+func NewMemoryMappingRepository() *MemoryMappingRepository {
+	return &MemoryMappingRepository{
+		collection: database.NewMemoryCollection[ValidationRuleMapping](),
+	}
+}
+
+func (repo *MemoryMappingRepository) Create(ctx context.Context, mapping ValidationRuleMapping) (primitive.ObjectID, error) {
+	if mapping.ID.IsZero() {
+		mapping.ID = primitive.NewObjectID()
+	}
+	return mapping.ID, repo.collection.Insert(mapping.ID, mapping)
+}
+
+func (repo *MemoryMappingRepository) List(ctx context.Context) ([]ValidationRuleMapping, error) {
+	return repo.collection.Find(nil)
+}
+
+func (repo *MemoryMappingRepository) ListByEnvironment(ctx context.Context, environment string) ([]ValidationRuleMapping, error) {
+	return repo.collection.Find(func(mapping ValidationRuleMapping) bool {
+		return mapping.Environments[environment] == true
+	})
+}
+
+func (repo *MemoryMappingRepository) Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRuleMapping, error) {
+	return searchMemory(repo.collection, filter)
+}
+
+func (repo *MemoryMappingRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRuleMapping, error) {
+	return repo.collection.Get(id)
+}
+
+func (repo *MemoryMappingRepository) Update(ctx context.Context, id primitive.ObjectID, mapping ValidationRuleMapping) error {
+	return repo.collection.Update(id, func(stored *ValidationRuleMapping) {
+		stored.RuleId = mapping.RuleId
+		stored.Environments = mapping.Environments
+		stored.Enforced = mapping.Enforced
+		stored.Selector = mapping.Selector
+		stored.RuleVersion = mapping.RuleVersion
+	})
+}
+
+func (repo *MemoryMappingRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return repo.collection.Delete(id)
+}
+
+// --------------------------------------------
+// Validation Rule Versions
+// --------------------------------------------
+
+// MemoryRuleVersionRepository keeps Validation Rule versions in process memory, used with DB_BACKEND=memory
+type MemoryRuleVersionRepository struct {
+	mutex      sync.Mutex
+	collection *database.MemoryCollection[RuleVersion]
+}
+
+// compile-time interface check
+var _ RuleVersionRepository = &MemoryRuleVersionRepository{}
+
+func NewMemoryRuleVersionRepository() *MemoryRuleVersionRepository {
+	return &MemoryRuleVersionRepository{
+		collection: database.NewMemoryCollection[RuleVersion](),
+	}
+}
+
+func (repo *MemoryRuleVersionRepository) Create(ctx context.Context, version RuleVersion) (primitive.ObjectID, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	// Matches the unique index on the MongoDB collection
+	if _, err := repo.Get(ctx, version.RuleId, version.Version); err != database.ErrNotFound {
+		if err == nil {
+			err = database.ErrDuplicateID
+		}
+		return primitive.NilObjectID, err
+	}
+
+	if version.ID.IsZero() {
+		version.ID = primitive.NewObjectID()
+	}
+	return version.ID, repo.collection.Insert(version.ID, version)
+}
+
+// Versions are created in order, so insertion order is version order
+func (repo *MemoryRuleVersionRepository) List(ctx context.Context, ruleID primitive.ObjectID) ([]RuleVersion, error) {
+	return repo.collection.Find(func(version RuleVersion) bool {
+		return version.RuleId == ruleID
+	})
+}
+
+func (repo *MemoryRuleVersionRepository) Get(ctx context.Context, ruleID primitive.ObjectID, version int) (*RuleVersion, error) {
+	versions, err := repo.collection.Find(func(ruleVersion RuleVersion) bool {
+		return ruleVersion.RuleId == ruleID && ruleVersion.Version == version
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, database.ErrNotFound
+	}
+	return &versions[0], nil
+}
+
+// --------------------------------------------
+// Validation Results
+// --------------------------------------------
+
+// MemoryResultRepository keeps the validation history in process memory, used with DB_BACKEND=memory
+type MemoryResultRepository struct {
+	collection *database.MemoryCollection[ValidationRecord]
+}
+
+// compile-time interface check
+var _ ResultRepository = &MemoryResultRepository{}
+
+func NewMemoryResultRepository() *MemoryResultRepository {
+	return &MemoryResultRepository{
+		collection: database.NewMemoryCollection[ValidationRecord](),
+	}
+}
+
+func (repo *MemoryResultRepository) Create(ctx context.Context, record ValidationRecord) (primitive.ObjectID, error) {
+	if record.ID.IsZero() {
+		record.ID = primitive.NewObjectID()
+	}
+	return record.ID, repo.collection.Insert(record.ID, record)
+}
+
+// Records are kept in insertion order, which is timestamp order
+func (repo *MemoryResultRepository) ListByArtifact(ctx context.Context, artifactID string) ([]ValidationRecord, error) {
+	return repo.collection.Find(func(record ValidationRecord) bool {
+		return record.ArtifactId == artifactID
+	})
+}
+
+func (repo *MemoryResultRepository) ListByEnvironment(ctx context.Context, environment string) ([]ValidationRecord, error) {
+	return repo.collection.Find(func(record ValidationRecord) bool {
+		return record.Environment == environment
+	})
+}
+
+func (repo *MemoryResultRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRecord, error) {
+	return repo.collection.Get(id)
+}
+
+// --------------------------------------------
+// Waivers
+// --------------------------------------------
+
+// MemoryWaiverRepository keeps Waivers in process memory, used with DB_BACKEND=memory
+type MemoryWaiverRepository struct {
+	collection *database.MemoryCollection[Waiver]
+}
+
+// compile-time interface check
+var _ WaiverRepository = &MemoryWaiverRepository{}
+
+func NewMemoryWaiverRepository() *MemoryWaiverRepository {
+	return &MemoryWaiverRepository{
+		collection: database.NewMemoryCollection[Waiver](),
+	}
+}
+
+func (repo *MemoryWaiverRepository) Create(ctx context.Context, waiver Waiver) (primitive.ObjectID, error) {
+	if waiver.ID.IsZero() {
+		waiver.ID = primitive.NewObjectID()
+	}
+	return waiver.ID, repo.collection.Insert(waiver.ID, waiver)
+}
+
+func (repo *MemoryWaiverRepository) List(ctx context.Context) ([]Waiver, error) {
+	return repo.collection.Find(nil)
+}
+
+func (repo *MemoryWaiverRepository) ListActive(ctx context.Context, environment string, at time.Time) ([]Waiver, error) {
+	return repo.collection.Find(func(waiver Waiver) bool {
+		if !waiver.ExpiresAt.After(at) {
+			return false
+		}
+		return containsString(waiver.Environments, environment)
+	})
+}
+
+func (repo *MemoryWaiverRepository) Get(ctx context.Context, id primitive.ObjectID) (*Waiver, error) {
+	return repo.collection.Get(id)
+}
+
+func (repo *MemoryWaiverRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return repo.collection.Delete(id)
+}
+
+// --------------------------------------------
+// Supporting Functions
+// --------------------------------------------
+
+func searchMemory[T any](collection *database.MemoryCollection[T], filter database.SearchFilter) ([]T, error) {
+	var searchErr error
+	records, err := collection.Find(func(record T) bool {
+		match, err := database.MatchesSearch(record, filter, filter.SearchKey)
+		if err != nil {
+			searchErr = err
+		}
+		return match
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, searchErr
+}
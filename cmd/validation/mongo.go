@@ -0,0 +1,373 @@
+package validation
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// --------------------------------------------
+// Validation Rules
+// --------------------------------------------
+
+// MongoRuleRepository stores Validation Rules in the validationdb database
+type MongoRuleRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ RuleRepository = &MongoRuleRepository{}
+
+func NewMongoRuleRepository(client *mongo.Client) *MongoRuleRepository {
+	return &MongoRuleRepository{
+		collection: client.Database(validationDbName).Collection(validationRuleColName),
+	}
+}
+
+func (repo *MongoRuleRepository) Create(ctx context.Context, rule ValidationRule) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, rule)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoRuleRepository) List(ctx context.Context) ([]ValidationRule, error) {
+	return findAll[ValidationRule](ctx, repo.collection, bson.M{})
+}
+
+func (repo *MongoRuleRepository) Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRule, error) {
+	return findAll[ValidationRule](ctx, repo.collection, searchQuery(filter))
+}
+
+func (repo *MongoRuleRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRule, error) {
+	return findOne[ValidationRule](ctx, repo.collection, id)
+}
+
+func (repo *MongoRuleRepository) Update(ctx context.Context, id primitive.ObjectID, version int, rule ValidationRule) error {
+	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
+	update := bson.M{
+		"$set": bson.M{
+			"name":         rule.Name,
+			"description":  rule.Description,
+			"ruleFamily":   rule.RuleFamily,
+			"ruleKey":      rule.RuleKey,
+			"ruleLimits":   rule.RuleLimits,
+			"condition":    rule.Condition,
+			"expression":   rule.Expression,
+			"rego":         rule.Rego,
+			"selector":     rule.Selector,
+			"dependencies": rule.Dependencies,
+			"version":      rule.Version,
+		},
+	}
+
+	// Rules stored before versioning have no version field, they are at version 0
+	filter := bson.M{"_id": id, "version": version}
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Nothing matched, either the rule is gone or another update got there first
+	count, err := repo.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return database.ErrNotFound
+	}
+	return database.ErrConflict
+}
+
+func (repo *MongoRuleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// --------------------------------------------
+// Validation Rule Mappings
+// --------------------------------------------
+
+// MongoMappingRepository stores Validation Rule Mappings in the validationdb database
+type MongoMappingRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ MappingRepository = &MongoMappingRepository{}
+
+func NewMongoMappingRepository(client *mongo.Client) *MongoMappingRepository {
+	return &MongoMappingRepository{
+		collection: client.Database(validationDbName).Collection(validationRuleMappingColName),
+	}
+}
+
+func (repo *MongoMappingRepository) Create(ctx context.Context, mapping ValidationRuleMapping) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, mapping)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoMappingRepository) List(ctx context.Context) ([]ValidationRuleMapping, error) {
+	return findAll[ValidationRuleMapping](ctx, repo.collection, bson.M{})
+}
+
+func (repo *MongoMappingRepository) ListByEnvironment(ctx context.Context, environment string) ([]ValidationRuleMapping, error) {
+	return findAll[ValidationRuleMapping](ctx, repo.collection, bson.M{"environments." + environment: true})
+}
+
+func (repo *MongoMappingRepository) Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRuleMapping, error) {
+	return findAll[ValidationRuleMapping](ctx, repo.collection, searchQuery(filter))
+}
+
+func (repo *MongoMappingRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRuleMapping, error) {
+	return findOne[ValidationRuleMapping](ctx, repo.collection, id)
+}
+
+func (repo *MongoMappingRepository) Update(ctx context.Context, id primitive.ObjectID, mapping ValidationRuleMapping) error {
+	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
+	update := bson.M{
+		"$set": bson.M{
+			"ruleId":       mapping.RuleId,
+			"environments": mapping.Environments,
+			"enforced":     mapping.Enforced,
+			"selector":     mapping.Selector,
+			"ruleVersion":  mapping.RuleVersion,
+		},
+	}
+	return updateOne(ctx, repo.collection, id, update)
+}
+
+func (repo *MongoMappingRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// --------------------------------------------
+// Validation Rule Versions
+// --------------------------------------------
+
+// MongoRuleVersionRepository stores Validation Rule versions in the validationdb database
+type MongoRuleVersionRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ RuleVersionRepository = &MongoRuleVersionRepository{}
+
+func NewMongoRuleVersionRepository(client *mongo.Client) *MongoRuleVersionRepository {
+	collection := client.Database(validationDbName).Collection(validationRuleVersionColName)
+
+	// Version numbers must be unique per rule, so concurrent updates can't both claim the same version
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "ruleId", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating validation rule version index:", err)
+	}
+
+	return &MongoRuleVersionRepository{
+		collection: collection,
+	}
+}
+
+func (repo *MongoRuleVersionRepository) Create(ctx context.Context, version RuleVersion) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, version)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoRuleVersionRepository) List(ctx context.Context, ruleID primitive.ObjectID) ([]RuleVersion, error) {
+	return findSorted[RuleVersion](ctx, repo.collection, bson.M{"ruleId": ruleID}, bson.D{{Key: "version", Value: 1}})
+}
+
+func (repo *MongoRuleVersionRepository) Get(ctx context.Context, ruleID primitive.ObjectID, version int) (*RuleVersion, error) {
+	var ruleVersion RuleVersion
+	err := repo.collection.FindOne(ctx, bson.M{"ruleId": ruleID, "version": version}).Decode(&ruleVersion)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ruleVersion, nil
+}
+
+// --------------------------------------------
+// Validation Results
+// --------------------------------------------
+
+// MongoResultRepository stores the validation history in the validationdb database
+type MongoResultRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ ResultRepository = &MongoResultRepository{}
+
+func NewMongoResultRepository(client *mongo.Client) *MongoResultRepository {
+	collection := client.Database(validationDbName).Collection(validationResultColName)
+
+	// History is listed per artifact & per environment
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "artifactId", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "environment", Value: 1}, {Key: "timestamp", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("Error creating validation result indexes:", err)
+	}
+
+	return &MongoResultRepository{
+		collection: collection,
+	}
+}
+
+func (repo *MongoResultRepository) Create(ctx context.Context, record ValidationRecord) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoResultRepository) ListByArtifact(ctx context.Context, artifactID string) ([]ValidationRecord, error) {
+	return findSorted[ValidationRecord](ctx, repo.collection, bson.M{"artifactId": artifactID}, bson.D{{Key: "timestamp", Value: 1}})
+}
+
+func (repo *MongoResultRepository) ListByEnvironment(ctx context.Context, environment string) ([]ValidationRecord, error) {
+	return findSorted[ValidationRecord](ctx, repo.collection, bson.M{"environment": environment}, bson.D{{Key: "timestamp", Value: 1}})
+}
+
+func (repo *MongoResultRepository) Get(ctx context.Context, id primitive.ObjectID) (*ValidationRecord, error) {
+	return findOne[ValidationRecord](ctx, repo.collection, id)
+}
+
+// --------------------------------------------
+// Waivers
+// --------------------------------------------
+
+// MongoWaiverRepository stores Waivers in the validationdb database
+type MongoWaiverRepository struct {
+	collection *mongo.Collection
+}
+
+// compile-time interface check
+var _ WaiverRepository = &MongoWaiverRepository{}
+
+func NewMongoWaiverRepository(client *mongo.Client) *MongoWaiverRepository {
+	return &MongoWaiverRepository{
+		collection: client.Database(validationDbName).Collection(waiverColName),
+	}
+}
+
+func (repo *MongoWaiverRepository) Create(ctx context.Context, waiver Waiver) (primitive.ObjectID, error) {
+	result, err := repo.collection.InsertOne(ctx, waiver)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoWaiverRepository) List(ctx context.Context) ([]Waiver, error) {
+	return findAll[Waiver](ctx, repo.collection, bson.M{})
+}
+
+func (repo *MongoWaiverRepository) ListActive(ctx context.Context, environment string, at time.Time) ([]Waiver, error) {
+	return findAll[Waiver](ctx, repo.collection, bson.M{"environments": environment, "expiresAt": bson.M{"$gt": at}})
+}
+
+func (repo *MongoWaiverRepository) Get(ctx context.Context, id primitive.ObjectID) (*Waiver, error) {
+	return findOne[Waiver](ctx, repo.collection, id)
+}
+
+func (repo *MongoWaiverRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// --------------------------------------------
+// Supporting Functions
+// --------------------------------------------
+
+// Build the query for the search endpoints
+func searchQuery(filter database.SearchFilter) bson.M {
+	query := bson.M{}
+	if filter.SearchKey != "" && filter.SearchValue != "" {
+		if filter.SearchVerb == "contains" {
+			query = bson.M{filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}}
+		} else {
+			query = bson.M{filter.SearchKey: filter.SearchValue}
+		}
+	}
+	return query
+}
+
+func findOne[T any](ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) (*T, error) {
+	var record T
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, query bson.M) ([]T, error) {
+	return findSorted[T](ctx, collection, query, nil)
+}
+
+func findSorted[T any](ctx context.Context, collection *mongo.Collection, query bson.M, sort bson.D) ([]T, error) {
+	opts := options.Find()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []T
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var record T
+		if err := cursor.Decode(&record); err != nil {
+			log.Printf("Error decoding %T: %v", record, err)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func updateOne(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, update bson.M) error {
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
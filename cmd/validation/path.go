@@ -0,0 +1,124 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A key segment with optional array steps, e.g. vulns, vulns[0] or vulns[*]
+var segmentPattern = regexp.MustCompile(`^([^\[\]]+)((?:\[(?:\*|\d+)\])*)$`)
+var indexPattern = regexp.MustCompile(`\[(\*|\d+)\]`)
+
+// Resolve a rule key (e.g. artifactMetadata.cve.high or artifactMetadata.vulns[*].severity) against the artifact
+// Keys with a wildcard resolve to a list of every matching value, elements missing the rest of the key are skipped
+func resolveKey(artifact artifacts.Artifact, ruleKey string) (any, bool) {
+	keys := strings.Split(ruleKey, ".")
+
+	// Plain keys are handled by lookup, which knows about the root fields
+	first := -1
+	for i, key := range keys {
+		if strings.Contains(key, "[") {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return lookup(artifact, artifact.ArtifactMetadata, keys)
+	}
+
+	segments := make([][]string, len(keys))
+	for i, key := range keys {
+		match := segmentPattern.FindStringSubmatch(key)
+		if match == nil {
+			return nil, false
+		}
+		segments[i] = []string{match[1]}
+		for _, index := range indexPattern.FindAllStringSubmatch(match[2], -1) {
+			segments[i] = append(segments[i], index[1])
+		}
+	}
+
+	// Resolve everything up to the first array step with lookup, then walk the rest
+	prefix := make([]string, first+1)
+	for i := range prefix {
+		prefix[i] = segments[i][0]
+	}
+	value, ok := lookup(artifact, artifact.ArtifactMetadata, prefix)
+	if !ok {
+		return nil, false
+	}
+
+	values := []any{value}
+	wildcard := false
+	for i := first; i < len(segments); i++ {
+		if i > first {
+			values = childValues(values, segments[i][0])
+		}
+		for _, index := range segments[i][1:] {
+			if index == "*" {
+				wildcard = true
+			}
+			values = elementValues(values, index)
+		}
+	}
+
+	if wildcard {
+		if values == nil {
+			values = []any{}
+		}
+		return values, true
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+// Get the field from every value that is a map, values without the field are dropped
+func childValues(values []any, field string) []any {
+	var children []any
+	for _, value := range values {
+		var child any
+		var ok bool
+		switch node := value.(type) {
+		case map[string]any:
+			child, ok = node[field]
+		case primitive.M:
+			child, ok = node[field]
+		case primitive.D:
+			for _, element := range node {
+				if element.Key == field {
+					child, ok = element.Value, true
+					break
+				}
+			}
+		}
+		if ok {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Get the indexed element (or every element for *) from every value that is a list
+func elementValues(values []any, index string) []any {
+	var elements []any
+	for _, value := range values {
+		list, ok := limitList(value)
+		if !ok {
+			continue
+		}
+		if index == "*" {
+			elements = append(elements, list...)
+			continue
+		}
+		i, err := strconv.Atoi(index)
+		if err == nil && i < len(list) {
+			elements = append(elements, list[i])
+		}
+	}
+	return elements
+}
@@ -0,0 +1,162 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"sort"
+	"time"
+)
+
+// RegoPolicy is an OPA Rego module evaluated in-process, every message in its deny set is a violation
+// The module's input is { "artifact": <the artifact document>, "environment": <environment name> }
+//
+//	package artifactflow.coverage
+//
+//	deny[msg] {
+//	    input.artifact.artifactMetadata.coverage < 80
+//	    msg := sprintf("coverage %v is below 80", [input.artifact.artifactMetadata.coverage])
+//	}
+type RegoPolicy struct {
+	Module      string
+	Environment string
+}
+
+// compile-time interface check
+var _ Constraint = RegoPolicy{}
+
+// Prepared deny queries, keyed by module source
+var regoQueries = newLRUCache[string, rego.PreparedEvalQuery](compiledCacheSize)
+
+// How long a policy may run, one that doesn't finish in time fails the rule
+const regoEvalTimeout = 5 * time.Second
+
+// Builtins policies can't call, they reach the network or expose the server's environment
+var regoDeniedBuiltins = map[string]bool{
+	"http.send":          true,
+	"net.lookup_ip_addr": true,
+	"opa.runtime":        true,
+}
+
+// The capabilities of this OPA version without the denied builtins, modules calling them don't compile
+var regoCapabilities = func() *ast.Capabilities {
+	capabilities := ast.CapabilitiesForThisVersion()
+
+	var builtins []*ast.Builtin
+	for _, builtin := range capabilities.Builtins {
+		if !regoDeniedBuiltins[builtin.Name] {
+			builtins = append(builtins, builtin)
+		}
+	}
+	capabilities.Builtins = builtins
+	capabilities.AllowNet = []string{}
+
+	return capabilities
+}()
+
+// Compile the module & prepare its deny query, the module must define deny
+func (policy RegoPolicy) Compile() (rego.PreparedEvalQuery, error) {
+	if query, ok := regoQueries.Load(policy.Module); ok {
+		return query, nil
+	}
+
+	module, err := ast.ParseModule("policy.rego", policy.Module)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	if module == nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("empty rego module")
+	}
+
+	definesDeny := false
+	for _, rule := range module.Rules {
+		if rule.Head.Name.String() == "deny" || rule.Head.Ref().String() == "deny" {
+			definesDeny = true
+			break
+		}
+	}
+	if !definesDeny {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("rego module %s must define deny", module.Package.Path)
+	}
+
+	query, err := rego.New(
+		rego.Query(module.Package.Path.String()+".deny"),
+		rego.Module("policy.rego", policy.Module),
+		rego.Capabilities(regoCapabilities),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+
+	regoQueries.Store(policy.Module, query)
+	return query, nil
+}
+
+// Evaluate implements Constraint, the rule key is not used as the policy addresses the artifact itself
+func (policy RegoPolicy) Evaluate(artifact artifacts.Artifact, ruleKey string) *ConstraintViolation {
+	return policy.evaluate(context.Background(), artifact)
+}
+
+// Evaluate the policy within the request, the evaluation is cancelled with it or after regoEvalTimeout
+func (policy RegoPolicy) evaluate(ctx context.Context, artifact artifacts.Artifact) *ConstraintViolation {
+	query, err := policy.Compile()
+	if err != nil {
+		return violation([]string{fmt.Sprintf("invalid rego module: %v", err)})
+	}
+
+	input, err := regoInput(artifact, policy.Environment)
+	if err != nil {
+		return violation([]string{fmt.Sprintf("unable to build rego input: %v", err)})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, regoEvalTimeout)
+	defer cancel()
+
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return violation([]string{fmt.Sprintf("rego evaluation failed: %v", err)})
+	}
+
+	var problems []string
+	for _, result := range results {
+		for _, expression := range result.Expressions {
+			messages, ok := expression.Value.([]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("deny must be a set of messages, got %v", expression.Value))
+				continue
+			}
+			for _, message := range messages {
+				if str, ok := message.(string); ok {
+					problems = append(problems, str)
+				} else {
+					problems = append(problems, fmt.Sprintf("%v", message))
+				}
+			}
+		}
+	}
+
+	// Sets come back unordered
+	sort.Strings(problems)
+	return violation(problems)
+}
+
+// The artifact is passed as its JSON document, the same shape the API returns
+func regoInput(artifact artifacts.Artifact, environment string) (map[string]interface{}, error) {
+	raw, err := json.Marshal(artifact)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"artifact":    document,
+		"environment": environment,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package validation
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// RuleRepository is the storage used by the Validation Rule handlers
+type RuleRepository interface {
+	Create(ctx context.Context, rule ValidationRule) (primitive.ObjectID, error)
+	List(ctx context.Context) ([]ValidationRule, error)
+	Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRule, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*ValidationRule, error)
+	// Update replaces the rule if it is still at the version the change was made to, database.ErrConflict otherwise
+	Update(ctx context.Context, id primitive.ObjectID, version int, rule ValidationRule) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MappingRepository is the storage used by the Validation Rule Mapping handlers
+type MappingRepository interface {
+	Create(ctx context.Context, mapping ValidationRuleMapping) (primitive.ObjectID, error)
+	List(ctx context.Context) ([]ValidationRuleMapping, error)
+	ListByEnvironment(ctx context.Context, environment string) ([]ValidationRuleMapping, error)
+	Search(ctx context.Context, filter database.SearchFilter) ([]ValidationRuleMapping, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*ValidationRuleMapping, error)
+	Update(ctx context.Context, id primitive.ObjectID, mapping ValidationRuleMapping) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// ResultRepository is the storage for the validation history, records are never updated
+type ResultRepository interface {
+	Create(ctx context.Context, record ValidationRecord) (primitive.ObjectID, error)
+	ListByArtifact(ctx context.Context, artifactID string) ([]ValidationRecord, error)
+	ListByEnvironment(ctx context.Context, environment string) ([]ValidationRecord, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*ValidationRecord, error)
+}
+
+// WaiverRepository is the storage used by the Waiver handlers
+type WaiverRepository interface {
+	Create(ctx context.Context, waiver Waiver) (primitive.ObjectID, error)
+	List(ctx context.Context) ([]Waiver, error)
+	ListActive(ctx context.Context, environment string, at time.Time) ([]Waiver, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*Waiver, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// RuleVersionRepository is the append-only storage for Validation Rule versions
+type RuleVersionRepository interface {
+	// Create stores the version, each version number can only be stored once per rule
+	Create(ctx context.Context, version RuleVersion) (primitive.ObjectID, error)
+	List(ctx context.Context, ruleID primitive.ObjectID) ([]RuleVersion, error)
+	Get(ctx context.Context, ruleID primitive.ObjectID, version int) (*RuleVersion, error)
+}
+
+// Repositories for the configured backend, created on first use
+var ruleRepository RuleRepository
+var mappingRepository MappingRepository
+var resultRepository ResultRepository
+var waiverRepository WaiverRepository
+var ruleVersionRepository RuleVersionRepository
+var repositoryOnce sync.Once
+
+func setupRepositories() {
+	if database.GetBackend() == database.MemoryBackend {
+		ruleRepository = NewMemoryRuleRepository()
+		mappingRepository = NewMemoryMappingRepository()
+		resultRepository = NewMemoryResultRepository()
+		waiverRepository = NewMemoryWaiverRepository()
+		ruleVersionRepository = NewMemoryRuleVersionRepository()
+	} else {
+		client, _ := database.SetupMongoDbClient()
+		ruleRepository = NewMongoRuleRepository(client)
+		mappingRepository = NewMongoMappingRepository(client)
+		resultRepository = NewMongoResultRepository(client)
+		waiverRepository = NewMongoWaiverRepository(client)
+		ruleVersionRepository = NewMongoRuleVersionRepository(client)
+	}
+}
+
+// Get the Validation Rule repository for the configured backend
+func GetRuleRepository() RuleRepository {
+	repositoryOnce.Do(setupRepositories)
+	return ruleRepository
+}
+
+// Get the Validation Rule Mapping repository for the configured backend
+func GetMappingRepository() MappingRepository {
+	repositoryOnce.Do(setupRepositories)
+	return mappingRepository
+}
+
+// Get the Validation result repository for the configured backend
+func GetResultRepository() ResultRepository {
+	repositoryOnce.Do(setupRepositories)
+	return resultRepository
+}
+
+// Get the Waiver repository for the configured backend
+func GetWaiverRepository() WaiverRepository {
+	repositoryOnce.Do(setupRepositories)
+	return waiverRepository
+}
+
+// Get the Validation Rule version repository for the configured backend
+func GetRuleVersionRepository() RuleVersionRepository {
+	repositoryOnce.Do(setupRepositories)
+	return ruleVersionRepository
+}
@@ -0,0 +1,134 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	auth "artifactflow.com/m/v2/cmd/auth"
+	database "artifactflow.com/m/v2/cmd/database"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ValidationRecord is a stored validation result, recorded every time a stored artifact is validated
+type ValidationRecord struct {
+	ID               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ValidationResult `bson:",inline"`
+	RuleSet          []ValidationRule `json:"ruleSet" bson:"ruleSet"`     // the rules as they were when evaluated
+	Source           string           `json:"source" bson:"source"`       // validation / batch / promotion
+	Actor            string           `json:"actor" bson:"actor"`         // user that requested the validation
+	Timestamp        time.Time        `json:"timestamp" bson:"timestamp"` // when the validation was made
+}
+
+// Collection for Validation results
+const validationResultColName = "validationresults"
+
+// Where a validation was requested from
+const (
+	SourceValidation = "validation"
+	SourceBatch      = "batch"
+	SourcePromotion  = "promotion"
+)
+
+// Evaluate a stored artifact against the environment & record the result in the validation history
+func ValidateAndRecord(r *http.Request, artifact *artifacts.Artifact, environment string, source string) (*ValidationResult, error) {
+	rules, err := getValidationRulesForEnvironment(r.Context(), artifact, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	result := evaluateAgainstRules(r.Context(), artifact, environment, rules)
+	recordValidation(r, result, rules, source)
+	return result, nil
+}
+
+// Failing to record the history shouldn't fail the validation itself, so errors are only logged
+func recordValidation(r *http.Request, result *ValidationResult, rules []mappedRule, source string) {
+	record := ValidationRecord{
+		ValidationResult: *result,
+		RuleSet:          make([]ValidationRule, 0, len(rules)),
+		Source:           source,
+		Actor:            auth.GetActor(r),
+		Timestamp:        time.Now().UTC(),
+	}
+	for _, mapped := range rules {
+		record.RuleSet = append(record.RuleSet, mapped.Rule)
+	}
+
+	if _, err := GetResultRepository().Create(r.Context(), record); err != nil {
+		log.Println("Error recording validation result:", err)
+	}
+}
+
+// Get the validation history of an artifact, oldest first
+func GetArtifactValidations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting the validation history of a specific artifact")
+	params := mux.Vars(r)
+
+	// Convert the string ID to an ObjectID to make sure it's valid
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid Artifact ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	records, err := GetResultRepository().ListByArtifact(r.Context(), id.Hex())
+	if err != nil {
+		http.Error(w, "Unable to retrieve validation results", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(records)
+}
+
+// Get the validation history of an environment, oldest first
+func GetEnvironmentValidations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting the validation history of a specific environment")
+	params := mux.Vars(r)
+
+	records, err := GetResultRepository().ListByEnvironment(r.Context(), params["name"])
+	if err != nil {
+		http.Error(w, "Unable to retrieve validation results", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(records)
+}
+
+// Get a specific validation result
+func GetValidation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting a specific validation result")
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid validation result ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	record, err := GetResultRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find validation result with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve validation result", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(record)
+}
@@ -0,0 +1,210 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	database "artifactflow.com/m/v2/cmd/database"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+)
+
+// Try a rule against sample artifacts and/or stored artifacts, without mapping it to an environment
+type RuleTestRequest struct {
+	Rule        *ValidationRule      `json:"rule,omitempty"`        // the unsaved rule, only used by Test Unsaved Rule
+	Artifacts   []artifacts.Artifact `json:"artifacts,omitempty"`   // sample artifact documents
+	ArtifactIDs []string             `json:"artifactIds,omitempty"` // stored artifacts
+	Environment string               `json:"environment,omitempty"` // passed to Rego policies as input.environment
+}
+
+type RuleTestResult struct {
+	RuleId  string               `json:"ruleId,omitempty"`
+	Passed  bool                 `json:"passed"` // every artifact passes the rule
+	Results []ArtifactTestResult `json:"results"`
+	Errors  map[string]string    `json:"errors,omitempty"` // artifact IDs that couldn't be loaded
+}
+
+// The outcome of the rule for one artifact, samples are listed first in the order given
+type ArtifactTestResult struct {
+	ArtifactId string                 `json:"artifactId,omitempty"` // unset for samples without an ID
+	Name       string                 `json:"name,omitempty"`
+	Applies    bool                   `json:"applies"` // the rule's selector matches the artifact, the rule is still evaluated when it doesn't
+	Passed     bool                   `json:"passed"`
+	Message    string                 `json:"message,omitempty"`
+	Checks     []CheckResult          `json:"checks"`
+	Values     map[string]interface{} `json:"values"` // the value found at each key the rule uses, missing keys are left out
+}
+
+// Test a saved Validation Rule
+func TestRule(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Testing a specific validationRule record")
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid validationRule ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	var req RuleTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", 422)
+		log.Println(err)
+		return
+	}
+
+	rule, err := GetRuleRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find validationRule with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve validationRule", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	testRule(w, r, *rule, req)
+}
+
+// Test a Validation Rule before saving it
+func TestUnsavedRule(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Testing an unsaved validationRule")
+
+	var req RuleTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", 422)
+		log.Println(err)
+		return
+	}
+
+	if req.Rule == nil {
+		http.Error(w, "A rule is required", 422)
+		return
+	}
+	if fieldErrors := req.Rule.validate(); len(fieldErrors) > 0 {
+		rejectRule(w, fieldErrors)
+		return
+	}
+
+	testRule(w, r, *req.Rule, req)
+}
+
+// Evaluate the rule against every sample & stored artifact in the request
+func testRule(w http.ResponseWriter, r *http.Request, rule ValidationRule, req RuleTestRequest) {
+	if len(req.Artifacts) == 0 && len(req.ArtifactIDs) == 0 {
+		http.Error(w, "At least one of artifacts or artifactIds is required", 422)
+		return
+	}
+
+	result := RuleTestResult{
+		Passed:  true,
+		Results: []ArtifactTestResult{},
+	}
+	if !rule.ID.IsZero() {
+		result.RuleId = rule.ID.Hex()
+	}
+
+	tested := append([]artifacts.Artifact{}, req.Artifacts...)
+	for _, artifactID := range req.ArtifactIDs {
+		id, err := primitive.ObjectIDFromHex(artifactID)
+		if err != nil {
+			result.addError(artifactID, "invalid artifact ID")
+			continue
+		}
+		artifact, err := artifacts.GetRepository().Get(r.Context(), id)
+		if err == database.ErrNotFound {
+			result.addError(artifactID, "artifact not found")
+			continue
+		}
+		if err != nil {
+			http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		tested = append(tested, *artifact)
+	}
+
+	keys := rule.keys()
+	for i := range tested {
+		artifact := &tested[i]
+
+		// The rule is evaluated as if it were mapped & enforced
+		ruleResult := validateArtifactAgainstRules(r.Context(), artifact, req.Environment, []mappedRule{{Rule: rule}})[0]
+
+		artifactResult := ArtifactTestResult{
+			Name:    artifact.Name,
+			Applies: rule.Selector.Matches(*artifact),
+			Passed:  ruleResult.Passed,
+			Message: ruleResult.Message,
+			Checks:  ruleResult.Checks,
+			Values:  make(map[string]interface{}),
+		}
+		if !artifact.ID.IsZero() {
+			artifactResult.ArtifactId = artifact.ID.Hex()
+		}
+		for _, key := range keys {
+			if value, found := resolveKey(*artifact, key); found {
+				artifactResult.Values[key] = value
+			}
+		}
+
+		if !artifactResult.Passed {
+			result.Passed = false
+		}
+		result.Results = append(result.Results, artifactResult)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Artifacts that couldn't be loaded fail the test
+func (result *RuleTestResult) addError(artifactID string, message string) {
+	if result.Errors == nil {
+		result.Errors = make(map[string]string)
+	}
+	result.Errors[artifactID] = message
+	result.Passed = false
+}
+
+// Every key the rule's limits & conditions are applied to, in the order they appear
+func (rule ValidationRule) keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	add(rule.RuleKey)
+
+	var walk func(condition Condition)
+	walk = func(condition Condition) {
+		add(condition.Key)
+		for _, child := range condition.AllOf {
+			walk(child)
+		}
+		for _, child := range condition.AnyOf {
+			walk(child)
+		}
+		if condition.Not != nil {
+			walk(*condition.Not)
+		}
+	}
+	if rule.Condition != nil {
+		walk(*rule.Condition)
+	}
+	if rule.Dependencies != nil {
+		add("dependencies")
+	}
+
+	return keys
+}
@@ -0,0 +1,233 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FieldError is one problem found in a rule definition, e.g. { "field": "ruleLimits[0].value", "message": "min requires a number" }
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Returned with 422 when a rule is rejected on create or update
+type RuleValidationError struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// Limit types that don't take a value
+var presenceLimits = map[string]bool{"set": true, "exists": true, "absent": true}
+
+// Every supported limit type
+var limitTypes = map[string]bool{
+	"equal": true, "notEqual": true, "min": true, "max": true, "in": true, "notIn": true, "matches": true,
+	"set": true, "exists": true, "absent": true, "semverMin": true, "semverMax": true, "semverRange": true,
+	"olderThan": true, "newerThan": true, "before": true, "after": true, "count": true, "any": true, "all": true, "none": true,
+}
+
+// Check the rule definition before it is saved, so mistakes are reported to the author rather than at validation time
+// Every problem is returned, keyed by the path of the field within the rule
+func (rule ValidationRule) validate() []FieldError {
+	var errors []FieldError
+
+	if rule.RuleKey != "" {
+		errors = append(errors, validateKey("ruleKey", rule.RuleKey)...)
+	} else if len(rule.RuleLimits) > 0 {
+		errors = append(errors, FieldError{Field: "ruleKey", Message: "ruleLimits require a ruleKey"})
+	}
+
+	for i, lim := range rule.RuleLimits {
+		errors = append(errors, lim.validate(fmt.Sprintf("ruleLimits[%d]", i))...)
+	}
+
+	if rule.Condition != nil {
+		errors = append(errors, rule.Condition.validate("condition", rule.RuleKey)...)
+	}
+
+	if rule.Expression != "" {
+		if _, err := rule.Expression.Compile(); err != nil {
+			errors = append(errors, FieldError{Field: "expression", Message: err.Error()})
+		}
+	}
+
+	if rule.Rego != "" {
+		if _, err := (RegoPolicy{Module: rule.Rego}).Compile(); err != nil {
+			errors = append(errors, FieldError{Field: "rego", Message: err.Error()})
+		}
+	}
+
+	if rule.Dependencies != nil {
+		switch rule.Dependencies.Require {
+		case "", RequireValidated, RequirePromoted:
+		default:
+			errors = append(errors, FieldError{Field: "dependencies.require", Message: fmt.Sprintf("unsupported requirement %s, expected %s or %s", rule.Dependencies.Require, RequireValidated, RequirePromoted)})
+		}
+	}
+
+	return errors
+}
+
+// Check a condition & its children, leaves with limits need a key of their own or from an enclosing condition or the rule
+func (condition Condition) validate(field string, ruleKey string) []FieldError {
+	var errors []FieldError
+
+	if condition.Key != "" {
+		errors = append(errors, validateKey(field+".key", condition.Key)...)
+		ruleKey = condition.Key
+	}
+
+	if len(condition.Limits) == 0 && len(condition.AllOf) == 0 && len(condition.AnyOf) == 0 && condition.Not == nil && condition.Expression == "" {
+		errors = append(errors, FieldError{Field: field, Message: "empty condition, expected limits, allOf, anyOf, not or expression"})
+	}
+
+	if len(condition.Limits) > 0 && ruleKey == "" {
+		errors = append(errors, FieldError{Field: field + ".key", Message: "limits require a key on the condition, an enclosing condition or the rule"})
+	}
+	for i, lim := range condition.Limits {
+		errors = append(errors, lim.validate(fmt.Sprintf("%s.limits[%d]", field, i))...)
+	}
+
+	for i, child := range condition.AllOf {
+		errors = append(errors, child.validate(fmt.Sprintf("%s.allOf[%d]", field, i), ruleKey)...)
+	}
+	for i, child := range condition.AnyOf {
+		errors = append(errors, child.validate(fmt.Sprintf("%s.anyOf[%d]", field, i), ruleKey)...)
+	}
+	if condition.Not != nil {
+		errors = append(errors, condition.Not.validate(field+".not", ruleKey)...)
+	}
+
+	if condition.Expression != "" {
+		if _, err := condition.Expression.Compile(); err != nil {
+			errors = append(errors, FieldError{Field: field + ".expression", Message: err.Error()})
+		}
+	}
+
+	return errors
+}
+
+// Check the limit type is supported & its value has the type the limit needs
+func (lim RuleLimit) validate(field string) []FieldError {
+	fail := func(subfield string, format string, args ...interface{}) []FieldError {
+		return []FieldError{{Field: field + subfield, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if lim.Type == "" {
+		return fail(".type", "a limit type is required")
+	}
+	if !limitTypes[lim.Type] {
+		return fail(".type", "unsupported limit type %s", lim.Type)
+	}
+	if presenceLimits[lim.Type] {
+		return nil
+	}
+	if lim.Value == nil {
+		return fail(".value", "limit type %s requires a value", lim.Type)
+	}
+
+	value := *lim.Value
+	switch lim.Type {
+	case "min", "max":
+		// Decimal strings are compared as numbers at runtime, so they're accepted here too
+		if text, isString := value.(string); isString {
+			if _, ok := toNumber(text); !ok {
+				return fail(".value", "limit type %s requires a number, %q is not a decimal number", lim.Type, text)
+			}
+			return nil
+		}
+		if _, ok := toNumber(value); !ok {
+			return fail(".value", "limit type %s requires a number, got %T", lim.Type, value)
+		}
+	case "equal", "notEqual":
+		switch value.(type) {
+		case string, bool:
+		default:
+			if _, ok := toNumber(value); !ok {
+				return fail(".value", "limit type %s requires a string, number or boolean, got %T", lim.Type, value)
+			}
+		}
+	case "in", "notIn":
+		if _, ok := limitList(value); !ok {
+			return fail(".value", "limit type %s requires a list of values, got %T", lim.Type, value)
+		}
+	case "matches":
+		pattern, ok := value.(string)
+		if !ok {
+			return fail(".value", "limit type matches requires a regular expression string, got %T", value)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fail(".value", "invalid regular expression: %v", err)
+		}
+	case "semverMin", "semverMax", "semverRange":
+		version, ok := value.(string)
+		if !ok {
+			return fail(".value", "limit type %s requires a version string, got %T", lim.Type, value)
+		}
+		if _, err := lim.versionConstraint(version); err != nil {
+			return fail(".value", "invalid version limit %s: %v", version, err)
+		}
+	case "olderThan", "newerThan":
+		duration, ok := value.(string)
+		if !ok {
+			return fail(".value", "limit type %s requires a duration such as 30d or 12h, got %T", lim.Type, value)
+		}
+		if _, err := parseDuration(duration); err != nil {
+			return fail(".value", "invalid duration %s: %v", duration, err)
+		}
+	case "before", "after":
+		if _, ok := toTime(value); !ok {
+			return fail(".value", "limit type %s requires an RFC 3339 timestamp, got %v", lim.Type, value)
+		}
+	case "any", "all", "none":
+		nested := RuleLimit{}
+		if err := decodeLimitValue(value, &nested); err != nil {
+			return fail(".value", "limit type %s requires a nested limit such as { \"type\": \"equal\", \"value\": \"CRITICAL\" }", lim.Type)
+		}
+		return nested.validate(field + ".value")
+	case "count":
+		if _, isString := value.(string); !isString {
+			if _, ok := toNumber(value); ok {
+				return nil
+			}
+		}
+		var limits countLimit
+		if err := decodeLimitValue(value, &limits); err != nil {
+			return fail(".value", "limit type count requires a number or an object with where/min/max")
+		}
+		var errors []FieldError
+		for _, bound := range []struct {
+			name  string
+			value *interface{}
+		}{{"min", limits.Min}, {"max", limits.Max}} {
+			if bound.value == nil {
+				continue
+			}
+			if _, isString := (*bound.value).(string); isString {
+				errors = append(errors, fail(".value."+bound.name, "count %s requires a number, got a string", bound.name)...)
+			} else if _, ok := toNumber(*bound.value); !ok {
+				errors = append(errors, fail(".value."+bound.name, "count %s requires a number, got %T", bound.name, *bound.value)...)
+			}
+		}
+		if limits.Where != nil {
+			errors = append(errors, limits.Where.validate(field+".value.where")...)
+		}
+		return errors
+	}
+	return nil
+}
+
+// Check a key path such as artifactMetadata.vulns[*].severity is well formed
+func validateKey(field string, key string) []FieldError {
+	for _, segment := range strings.Split(key, ".") {
+		if segment == "" {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("invalid key %s, keys must not contain empty segments", key)}}
+		}
+		if strings.ContainsAny(segment, "[]") && !segmentPattern.MatchString(segment) {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("invalid key %s, %s must be a field name followed by [index] or [*] steps", key, segment)}}
+		}
+	}
+	return nil
+}
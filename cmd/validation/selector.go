@@ -0,0 +1,61 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+)
+
+// ArtifactSelector scopes a rule or mapping to the artifacts it applies to
+// Each field that is set must match, an empty selector matches every artifact
+type ArtifactSelector struct {
+	ArtifactTypes    []string          `json:"artifactTypes,omitempty" bson:"artifactTypes,omitempty"`       // [ "docker", "jar" ], any of
+	ArtifactFamilies []string          `json:"artifactFamilies,omitempty" bson:"artifactFamilies,omitempty"` // [ "payments" ], any of
+	Labels           map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`                     // { tier: "backend" }, all of, matched against artifactMetadata.labels
+}
+
+// Check whether the selector matches an artifact, a nil selector matches every artifact
+func (selector *ArtifactSelector) Matches(artifact artifacts.Artifact) bool {
+	if selector == nil {
+		return true
+	}
+	if len(selector.ArtifactTypes) > 0 && !containsString(selector.ArtifactTypes, artifact.ArtifactType) {
+		return false
+	}
+	if len(selector.ArtifactFamilies) > 0 && !containsString(selector.ArtifactFamilies, artifact.ArtifactFamily) {
+		return false
+	}
+	if len(selector.Labels) == 0 {
+		return true
+	}
+
+	labels, ok := artifact.ArtifactMetadata["labels"]
+	if !ok {
+		return false
+	}
+	for key, expected := range selector.Labels {
+		values := childValues([]any{labels}, key)
+		if len(values) == 0 || !equalValues(values[0], expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keep the rules whose rule & mapping selectors both match the artifact
+func applicableRules(artifact artifacts.Artifact, rules []mappedRule) []mappedRule {
+	var applicable []mappedRule
+	for _, mapped := range rules {
+		if mapped.Rule.Selector.Matches(artifact) && mapped.Mapping.Selector.Matches(artifact) {
+			applicable = append(applicable, mapped)
+		}
+	}
+	return applicable
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
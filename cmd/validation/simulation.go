@@ -0,0 +1,219 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Simulate a proposed rule and/or mapping in an environment across the stored artifacts, nothing is saved
+// A rule or mapping with the ID of a stored one replaces it, one without an ID is added
+type SimulationRequest struct {
+	Environment string                 `json:"environment"`
+	Rule        *ValidationRule        `json:"rule,omitempty"`    // mapped & enforced in the environment when no mapping is given
+	Mapping     *ValidationRuleMapping `json:"mapping,omitempty"` // a mapping without the environment removes the rule from it
+	Filter      *database.SearchFilter `json:"filter,omitempty"`  // only simulate artifacts matching the search, as with Search Artifacts
+}
+
+type SimulationResult struct {
+	Environment   string              `json:"environment"`
+	Total         int                 `json:"total"`         // artifacts evaluated
+	PassingBefore int                 `json:"passingBefore"` // artifacts passing the current rules
+	PassingAfter  int                 `json:"passingAfter"`  // artifacts passing with the proposed change
+	NewlyFailing  int                 `json:"newlyFailing"`
+	NewlyPassing  int                 `json:"newlyPassing"`
+	Changed       []SimulatedArtifact `json:"changed"` // artifacts whose outcome would change
+}
+
+type SimulatedArtifact struct {
+	ArtifactId   string            `json:"artifactId"`
+	Name         string            `json:"name,omitempty"`
+	PassesBefore bool              `json:"passesBefore"`
+	PassesAfter  bool              `json:"passesAfter"`
+	Violations   map[string]string `json:"violations,omitempty"` // violations with the proposed change, keyed by rule ID
+}
+
+// Simulate the impact of a Validation Rule or Mapping change
+func SimulatePolicy(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Simulating a validation policy change")
+
+	var req SimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", 422)
+		log.Println(err)
+		return
+	}
+
+	if req.Environment == "" {
+		http.Error(w, "An environment is required", 422)
+		return
+	}
+	if req.Rule == nil && req.Mapping == nil {
+		http.Error(w, "At least one of rule or mapping is required", 422)
+		return
+	}
+	if req.Rule != nil {
+		if fieldErrors := req.Rule.validate(); len(fieldErrors) > 0 {
+			rejectRule(w, fieldErrors)
+			return
+		}
+	}
+	// The mapping would bring in the stored rule, the proposed one would never be simulated
+	if req.Rule != nil && req.Mapping != nil && !req.Mapping.RuleId.IsZero() && req.Mapping.RuleId != req.Rule.ID {
+		http.Error(w, "The mapping's ruleId must match the rule's id, leave it unset to map the proposed rule", 422)
+		return
+	}
+
+	current, err := loadMappedRules(r.Context(), req.Environment, make(ruleCache))
+	if err != nil {
+		http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if pinnedOnly(current, req) {
+		http.Error(w, fmt.Sprintf("Validation Rule %s is only mapped to %s at pinned versions, which a proposed rule doesn't replace, include a mapping to simulate it", req.Rule.ID.Hex(), req.Environment), 422)
+		return
+	}
+
+	proposed, err := proposeRules(current, req)
+	if err == database.ErrNotFound {
+		http.Error(w, "Validation Rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	result := SimulationResult{
+		Environment: req.Environment,
+		Changed:     []SimulatedArtifact{},
+	}
+
+	// Artifacts are streamed one at a time, so the whole collection is never held in memory
+	err = artifacts.GetRepository().Each(r.Context(), req.Filter, func(artifact artifacts.Artifact) error {
+		before := evaluateAgainstRules(r.Context(), &artifact, req.Environment, applicableRules(artifact, current))
+		after := evaluateAgainstRules(r.Context(), &artifact, req.Environment, applicableRules(artifact, proposed))
+
+		result.Total++
+		if before.PassesValidation {
+			result.PassingBefore++
+		}
+		if after.PassesValidation {
+			result.PassingAfter++
+		}
+		if before.PassesValidation == after.PassesValidation {
+			return nil
+		}
+
+		if before.PassesValidation {
+			result.NewlyFailing++
+		} else {
+			result.NewlyPassing++
+		}
+		result.Changed = append(result.Changed, SimulatedArtifact{
+			ArtifactId:   artifact.ID.Hex(),
+			Name:         artifact.Name,
+			PassesBefore: before.PassesValidation,
+			PassesAfter:  after.PassesValidation,
+			Violations:   after.Violations,
+		})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Unable to retrieve artifacts", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Apply the proposed rule & mapping to the rules currently mapped to the environment
+func proposeRules(current []mappedRule, req SimulationRequest) ([]mappedRule, error) {
+	var proposed []mappedRule
+
+	// A changed rule replaces the stored one wherever it is mapped, except where a version is pinned
+	for _, mapped := range current {
+		if req.Rule != nil && !req.Rule.ID.IsZero() && mapped.Rule.ID == req.Rule.ID && mapped.Mapping.RuleVersion == 0 {
+			mapped.Rule = *req.Rule
+		}
+		if req.Mapping != nil && !req.Mapping.ID.IsZero() && mapped.Mapping.ID == req.Mapping.ID {
+			continue
+		}
+		proposed = append(proposed, mapped)
+	}
+
+	if req.Mapping == nil {
+		// A rule that isn't mapped yet is simulated as enforced in the environment
+		for _, mapped := range proposed {
+			if !req.Rule.ID.IsZero() && mapped.Rule.ID == req.Rule.ID {
+				return proposed, nil
+			}
+		}
+		return appendWithWaivers(proposed, mappedRule{Rule: *req.Rule, Mapping: ValidationRuleMapping{RuleId: req.Rule.ID}}, req.Environment)
+	}
+
+	if req.Mapping.Environments[req.Environment] != true {
+		return proposed, nil
+	}
+
+	mapped := mappedRule{Mapping: *req.Mapping}
+	if req.Rule != nil && (req.Mapping.RuleId.IsZero() || req.Mapping.RuleId == req.Rule.ID) {
+		mapped.Rule = *req.Rule
+	} else {
+		rule, err := getRuleForMapping(context.TODO(), *req.Mapping)
+		if err != nil {
+			return nil, err
+		}
+		mapped.Rule = *rule
+	}
+
+	return appendWithWaivers(proposed, mapped, req.Environment)
+}
+
+// Whether the proposed rule is a stored one mapped to the environment, but only by mappings pinning a version
+// Those keep evaluating the pinned version, so without a mapping of its own the change would show no impact
+func pinnedOnly(current []mappedRule, req SimulationRequest) bool {
+	if req.Rule == nil || req.Rule.ID.IsZero() || req.Mapping != nil {
+		return false
+	}
+
+	pinned := false
+	for _, mapped := range current {
+		if mapped.Rule.ID != req.Rule.ID {
+			continue
+		}
+		if mapped.Mapping.RuleVersion == 0 {
+			return false
+		}
+		pinned = true
+	}
+	return pinned
+}
+
+// Add a newly mapped rule along with its active waivers, rules that haven't been saved can't have any
+func appendWithWaivers(rules []mappedRule, mapped mappedRule, environment string) ([]mappedRule, error) {
+	if mapped.Rule.ID.IsZero() {
+		return append(rules, mapped), nil
+	}
+
+	waivers, err := getActiveWaivers(context.TODO(), environment)
+	if err != nil {
+		return nil, err
+	}
+	for _, waiver := range waivers {
+		if waiver.RuleId == mapped.Rule.ID {
+			mapped.Waivers = append(mapped.Waivers, waiver)
+		}
+	}
+	return append(rules, mapped), nil
+}
@@ -0,0 +1,314 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The actor recorded against rule versions written by the policy sync
+const syncActor = "policy-sync"
+
+// How often the policy directory is reconciled when POLICY_SYNC_INTERVAL isn't set
+const defaultSyncInterval = 30 * time.Second
+
+// Keeps the stored rules & mappings in line with a directory of bundle files, e.g. a git checkout
+type PolicySyncConfig struct {
+	Directory string        // unset disables the sync
+	Interval  time.Duration // how often the directory is reconciled
+	DryRun    bool          // only report drift, never change the stored policy
+	ReadOnly  bool          // reject changes to rules & mappings made through the API
+}
+
+type PolicySyncStatus struct {
+	Enabled     bool        `json:"enabled"`
+	Directory   string      `json:"directory,omitempty"`
+	DryRun      bool        `json:"dryRun"`
+	ReadOnly    bool        `json:"readOnly"`
+	Files       []string    `json:"files,omitempty"`       // the bundle files read, relative to the directory
+	LastSync    *time.Time  `json:"lastSync,omitempty"`    // when the directory was last reconciled
+	LastApplied *time.Time  `json:"lastApplied,omitempty"` // when changes were last made to the stored policy
+	InSync      bool        `json:"inSync"`                // the stored policy matches the directory
+	Drift       *PolicyPlan `json:"drift,omitempty"`       // the changes found by the last sync, applied unless dryRun
+	Errors      []string    `json:"errors,omitempty"`      // why the last sync couldn't reconcile the policy
+}
+
+var policySync = struct {
+	mutex   sync.Mutex // guards config & status, never held while the directory or store is read
+	running sync.Mutex // only one reconcile at a time
+	config  PolicySyncConfig
+	status  PolicySyncStatus
+}{}
+
+// Matches the index of a rule or mapping in a field error, so it can be traced back to its file
+var bundleField = regexp.MustCompile(`^(rules|mappings)\[(\d+)\](.*)$`)
+
+// --------------------------------------------
+// Sync Endpoints
+// --------------------------------------------
+
+// Report the drift & errors found by the last sync
+func GetPolicySync(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting the policy sync status")
+
+	json.NewEncoder(w).Encode(PolicySyncStatusNow())
+}
+
+// Reconcile the policy directory now rather than waiting for the next interval, e.g. from a git webhook
+func TriggerPolicySync(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Triggering a policy sync")
+
+	if PolicySyncStatusNow().Enabled == false {
+		http.Error(w, "Policy sync is not enabled, set POLICY_SYNC_DIR", http.StatusConflict)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SyncPolicy(r.Context()))
+}
+
+// --------------------------------------------
+// Supporting Functions
+// --------------------------------------------
+
+// Read the sync configuration from POLICY_SYNC_DIR, POLICY_SYNC_INTERVAL, POLICY_SYNC_DRY_RUN & POLICY_READ_ONLY
+func PolicySyncConfigFromEnv() PolicySyncConfig {
+	config := PolicySyncConfig{
+		Directory: os.Getenv("POLICY_SYNC_DIR"),
+		Interval:  defaultSyncInterval,
+		DryRun:    os.Getenv("POLICY_SYNC_DRY_RUN") == "true",
+		ReadOnly:  os.Getenv("POLICY_READ_ONLY") == "true",
+	}
+	if interval := os.Getenv("POLICY_SYNC_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			fmt.Println("Warning: Ignoring invalid POLICY_SYNC_INTERVAL", interval)
+		} else {
+			config.Interval = parsed
+		}
+	}
+	return config
+}
+
+// Configure the sync & reconcile the directory every interval in the background
+func StartPolicySync(config PolicySyncConfig) {
+	ConfigurePolicySync(config)
+	if config.Directory == "" {
+		return
+	}
+
+	fmt.Println("Info: Syncing the validation policy from", config.Directory, "every", config.Interval)
+	go func() {
+		for {
+			SyncPolicy(context.Background())
+			time.Sleep(config.Interval)
+		}
+	}()
+}
+
+// Set the sync configuration, clearing the status of any previous sync
+func ConfigurePolicySync(config PolicySyncConfig) {
+	policySync.mutex.Lock()
+	defer policySync.mutex.Unlock()
+
+	policySync.config = config
+	policySync.status = PolicySyncStatus{
+		Enabled:   config.Directory != "",
+		Directory: config.Directory,
+		DryRun:    config.DryRun,
+		ReadOnly:  config.ReadOnly,
+	}
+}
+
+func PolicySyncStatusNow() PolicySyncStatus {
+	policySync.mutex.Lock()
+	defer policySync.mutex.Unlock()
+	return policySync.status
+}
+
+// Reconcile the stored rules & mappings with the policy directory once
+// The API isn't blocked while the store is reconciled, only the status update takes the lock
+func SyncPolicy(ctx context.Context) PolicySyncStatus {
+	policySync.running.Lock()
+	defer policySync.running.Unlock()
+
+	policySync.mutex.Lock()
+	config := policySync.config
+	status := policySync.status
+	policySync.mutex.Unlock()
+
+	if config.Directory == "" {
+		return status
+	}
+	status = reconcilePolicy(ctx, config, status)
+
+	// A reconfiguration during the sync wins, its fresh status isn't overwritten
+	policySync.mutex.Lock()
+	defer policySync.mutex.Unlock()
+	if policySync.config == config {
+		policySync.status = status
+	}
+	return status
+}
+
+func reconcilePolicy(ctx context.Context, config PolicySyncConfig, status PolicySyncStatus) PolicySyncStatus {
+	now := time.Now().UTC()
+	status.LastSync = &now
+	status.InSync = false
+	status.Drift = nil
+	status.Errors = nil
+
+	bundle, files, errs := readPolicyDirectory(config.Directory)
+	status.Files = files
+	if len(errs) > 0 {
+		status.Errors = errs
+		return status
+	}
+
+	plan, fieldErrors, err := PlanBundle(ctx, bundle.PolicyBundle)
+	if err != nil {
+		log.Println(err)
+		status.Errors = []string{"unable to read the stored policy: " + err.Error()}
+		return status
+	}
+	for _, fieldError := range fieldErrors {
+		status.Errors = append(status.Errors, bundle.locate(fieldError))
+	}
+	if len(fieldErrors) > 0 {
+		return status
+	}
+
+	if plan.Creates+plan.Updates+plan.Deletes == 0 {
+		status.InSync = true
+		return status
+	}
+
+	status.Drift = plan
+	if config.DryRun {
+		return status
+	}
+
+	fmt.Println("Info: Reconciling the validation policy,", plan.Creates, "create(s),", plan.Updates, "update(s),", plan.Deletes, "delete(s)")
+	if err := ApplyPlan(ctx, syncActor, plan); err != nil {
+		log.Println(err)
+		status.Errors = []string{"unable to apply the policy: " + err.Error()}
+		return status
+	}
+	status.LastApplied = &now
+	status.InSync = true
+	return status
+}
+
+// Reject changes to rules & mappings made through the API while the policy is read-only
+// Waivers aren't policy, they are time limited exceptions granted per artifact, so they stay writable
+func policyReadOnly(w http.ResponseWriter) bool {
+	status := PolicySyncStatusNow()
+	if !status.ReadOnly {
+		return false
+	}
+
+	message := "The validation policy is read-only"
+	if status.Directory != "" {
+		message += ", it is managed from " + status.Directory
+	}
+	http.Error(w, message, http.StatusForbidden)
+	return true
+}
+
+// A bundle merged from several files, remembering which file each rule & mapping came from
+type directoryBundle struct {
+	PolicyBundle
+	ruleFiles    []fileEntry
+	mappingFiles []fileEntry
+}
+
+type fileEntry struct {
+	file  string
+	index int
+}
+
+// Merge every .yaml, .yml & .json file under the directory into one bundle, hidden directories like .git are skipped
+// An empty directory is an error, rather than a request to delete the whole policy
+func readPolicyDirectory(directory string) (*directoryBundle, []string, []string) {
+	bundle := &directoryBundle{PolicyBundle: PolicyBundle{Rules: []BundleRule{}, Mappings: []BundleMapping{}}}
+	var files []string
+	var errs []string
+
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != directory && entry.Name()[0] == '.' {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+
+		name, _ := filepath.Rel(directory, path)
+		files = append(files, name)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+			return nil
+		}
+		parsed, err := ParseBundle(data)
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+			return nil
+		}
+		for i, rule := range parsed.Rules {
+			bundle.Rules = append(bundle.Rules, rule)
+			bundle.ruleFiles = append(bundle.ruleFiles, fileEntry{file: name, index: i})
+		}
+		for i, mapping := range parsed.Mappings {
+			bundle.Mappings = append(bundle.Mappings, mapping)
+			bundle.mappingFiles = append(bundle.mappingFiles, fileEntry{file: name, index: i})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, files, []string{"unable to read " + directory + ": " + err.Error()}
+	}
+	if len(errs) == 0 && len(files) == 0 {
+		errs = append(errs, "no policy files found in "+directory)
+	}
+	return bundle, files, errs
+}
+
+// Describe a field error against the file & index it came from, e.g. team-a/rules.yaml: rules[0].ruleKey
+func (bundle *directoryBundle) locate(fieldError FieldError) string {
+	match := bundleField.FindStringSubmatch(fieldError.Field)
+	if match == nil {
+		return fieldError.Field + ": " + fieldError.Message
+	}
+
+	index, _ := strconv.Atoi(match[2])
+	entries := bundle.ruleFiles
+	if match[1] == "mappings" {
+		entries = bundle.mappingFiles
+	}
+	if index >= len(entries) {
+		return fieldError.Field + ": " + fieldError.Message
+	}
+	entry := entries[index]
+	return fmt.Sprintf("%s: %s[%d]%s: %s", entry.file, match[1], entry.index, match[3], fieldError.Message)
+}
@@ -2,36 +2,141 @@ package validation
 
 import (
 	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	auth "artifactflow.com/m/v2/cmd/auth"
 	database "artifactflow.com/m/v2/cmd/database"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
 	"net/http"
+	"strings"
 )
 
 type RuleLimit struct {
 	Type  string       `json:"type" bson:"type,omitempty"`
-	Value *interface{} `json:"value,omitempty" bson:"value,omitempty`
+	Value *interface{} `json:"value,omitempty" bson:"value,omitempty"`
 }
 
 type ValidationRule struct {
-	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Name        string             `json:"name,omitempty" bson:"name,omitempty"`               // 80percent_code_coverage
-	Description string             `json:"description,omitempty" bson:"description,omitempty"` // All code must have at least 80% code coverage
-	RuleFamily  string             `json:"ruleFamily,omitempty" bson:"ruleFamily,omitempty"`   // code
-	RuleLimits  []RuleLimit        `json:"ruleLimits,omitempty" bson:"ruleLimits,omitempty"`   // { min: 5, max: 10 } / { value: 3 }
-	RuleKey     string             `json:"ruleKey,omitempty" bson:"ruleKey,omitempty"`         // metadata.cve.high
+	ID           primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
+	Name         string                 `json:"name,omitempty" bson:"name,omitempty"`                 // 80percent_code_coverage
+	Description  string                 `json:"description,omitempty" bson:"description,omitempty"`   // All code must have at least 80% code coverage
+	RuleFamily   string                 `json:"ruleFamily,omitempty" bson:"ruleFamily,omitempty"`     // code
+	RuleLimits   []RuleLimit            `json:"ruleLimits,omitempty" bson:"ruleLimits,omitempty"`     // { min: 5, max: 10 } / { value: 3 }
+	RuleKey      string                 `json:"ruleKey,omitempty" bson:"ruleKey,omitempty"`           // metadata.cve.high
+	Condition    *Condition             `json:"condition,omitempty" bson:"condition,omitempty"`       // { anyOf: [...] }, combined with ruleLimits when both are set
+	Expression   Expression             `json:"expression,omitempty" bson:"expression,omitempty"`     // artifactMetadata.coverage >= 80
+	Rego         string                 `json:"rego,omitempty" bson:"rego,omitempty"`                 // package artifactflow.coverage deny[msg] { ... }
+	Selector     *ArtifactSelector      `json:"selector,omitempty" bson:"selector,omitempty"`         // { artifactTypes: [ "jar" ] }, the rule is skipped for other artifacts
+	Dependencies *DependencyRequirement `json:"dependencies,omitempty" bson:"dependencies,omitempty"` // { require: "validated" }, every dependency of the artifact must pass in the environment
+	Version      int                    `json:"version,omitempty" bson:"version,omitempty"`           // set by the server, incremented on every update
+}
+
+// Build the constraint for the rule in an environment, its limits against the rule key, condition, expression & policy must all pass
+func (rule ValidationRule) Constraint(environment string) Constraint {
+	var parts AllOf
+	for _, lim := range rule.RuleLimits {
+		parts = append(parts, lim)
+	}
+	if rule.Condition != nil {
+		parts = append(parts, *rule.Condition)
+	}
+	if rule.Expression != "" {
+		parts = append(parts, rule.Expression)
+	}
+	if rule.Rego != "" {
+		parts = append(parts, RegoPolicy{Module: rule.Rego, Environment: environment})
+	}
+	if rule.Dependencies != nil {
+		parts = append(parts, DependencyPolicy{Requirement: *rule.Dependencies, Environment: environment})
+	}
+	return parts
+}
+
+// Evaluate the rule against an artifact for an environment, a rule without anything to check always passes
+func (rule ValidationRule) Evaluate(artifact artifacts.Artifact, environment string) *ConstraintViolation {
+	if len(rule.RuleLimits) == 0 && rule.Condition == nil && rule.Expression == "" && rule.Rego == "" && rule.Dependencies == nil {
+		return nil
+	}
+	return rule.Constraint(environment).Evaluate(artifact, rule.RuleKey)
+}
+
+// Evaluate each part of the rule separately, so the report shows which limit failed & what it found
+func (rule ValidationRule) Check(artifact artifacts.Artifact, environment string) []CheckResult {
+	return rule.check(context.Background(), artifact, environment, nil)
+}
+
+// Check the rule, dependencies are checked within the graph being walked
+func (rule ValidationRule) check(ctx context.Context, artifact artifacts.Artifact, environment string, graph *dependencyGraph) []CheckResult {
+	checks := []CheckResult{}
+
+	for _, lim := range rule.RuleLimits {
+		value, found := resolveKey(artifact, rule.RuleKey)
+		check := CheckResult{Key: rule.RuleKey, LimitType: lim.Type, Expected: describeValue(lim.Value)}
+		if found {
+			check.Actual = value
+		}
+		checks = append(checks, check.record(lim.evaluateValue(value, found, rule.RuleKey)))
+	}
+	if rule.Condition != nil {
+		check := CheckResult{Key: rule.Condition.Key, LimitType: "condition", Expected: *rule.Condition}
+		checks = append(checks, check.record(rule.Condition.Evaluate(artifact, rule.RuleKey)))
+	}
+	if rule.Expression != "" {
+		check := CheckResult{LimitType: "expression", Expected: string(rule.Expression)}
+		checks = append(checks, check.record(rule.Expression.Evaluate(artifact, rule.RuleKey)))
+	}
+	if rule.Rego != "" {
+		check := CheckResult{LimitType: "rego"}
+		checks = append(checks, check.record(RegoPolicy{Module: rule.Rego, Environment: environment}.evaluate(ctx, artifact)))
+	}
+	if rule.Dependencies != nil {
+		checks = append(checks, DependencyPolicy{Requirement: *rule.Dependencies, Environment: environment, graph: graph}.check(ctx, artifact)...)
+	}
+
+	return checks
+}
+
+func (check CheckResult) record(err *ConstraintViolation) CheckResult {
+	check.Passed = err == nil
+	if err != nil {
+		check.Message = err.Error()
+	}
+	return check
+}
+
+// Violations & warnings are reported against the rule key, composite rules without one use the rule name
+func (rule ValidationRule) resultKey() string {
+	if rule.RuleKey != "" {
+		return rule.RuleKey
+	}
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return rule.ID.Hex()
 }
 
 type ValidationRuleMapping struct {
 	ID                 primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
 	RuleId             primitive.ObjectID     `json:"ruleId,omitempty" bson:"ruleId,omitempty"`                         // 647f85e6e9fd4a733a4c6b8b
 	Environments       map[string]interface{} `json:"environments,omitempty" bson:"environments,omitempty"`             // { development: true }
-	Enforced           bool                   `json:"enforced,omitempty" bson:"enforced,omitempty"`                     // false / true
+	Enforced           *bool                  `json:"enforced,omitempty" bson:"enforced,omitempty"`                     // false / true, unset is treated as true
+	Selector           *ArtifactSelector      `json:"selector,omitempty" bson:"selector,omitempty"`                     // { artifactFamilies: [ "payments" ] }, narrows the rule in these environments
+	RuleVersion        int                    `json:"ruleVersion,omitempty" bson:"ruleVersion,omitempty"`               // 2, pins a version of the rule, unset tracks the latest
+}
+
+// Failures of non-enforced mappings are reported as warnings rather than violations
+func (mapping ValidationRuleMapping) IsEnforced() bool {
+	return mapping.Enforced == nil || *mapping.Enforced
+}
+
+// A rule as it applies to an environment, through the mapping that placed it there
+type mappedRule struct {
+	Rule    ValidationRule
+	Mapping ValidationRuleMapping
+	Waivers []Waiver // active waivers of the rule in the mapped environment
 }
 
 type ValidationRequest struct {
@@ -39,14 +144,52 @@ type ValidationRequest struct {
 	Environment string `json:"environment"`
 }
 
+// Dry-run validation of an artifact document that hasn't been stored
+type EvaluationRequest struct {
+	Artifact    *artifacts.Artifact `json:"artifact"`
+	Environment string              `json:"environment"`
+}
+
+type ValidationResult struct {
+	ArtifactId       string            `json:"artifactId" bson:"artifactId"`
+	PassesValidation bool              `json:"passesValidation" bson:"passesValidation"`
+	Environment      string            `json:"environment" bson:"environment"`
+	Rules            []RuleResult      `json:"rules" bson:"rules"`                               // every rule evaluated, in mapping order
+	Violations       map[string]string `json:"violations,omitempty" bson:"violations,omitempty"` // failures of enforced rules, keyed by rule ID
+	Warnings         map[string]string `json:"warnings,omitempty" bson:"warnings,omitempty"`     // failures of non-enforced rules keyed by rule ID, these don't fail validation
+	Waived           map[string]string `json:"waived,omitempty" bson:"waived,omitempty"`         // failures covered by an active waiver keyed by rule ID, these don't fail validation
+}
+
+// The outcome of evaluating one rule against an artifact
+type RuleResult struct {
+	RuleId      string        `json:"ruleId" bson:"ruleId"`
+	Name        string        `json:"name,omitempty" bson:"name,omitempty"`
+	RuleFamily  string        `json:"ruleFamily,omitempty" bson:"ruleFamily,omitempty"`
+	RuleKey     string        `json:"ruleKey,omitempty" bson:"ruleKey,omitempty"`
+	RuleVersion int           `json:"ruleVersion,omitempty" bson:"ruleVersion,omitempty"` // the version of the rule that was evaluated
+	Enforced    bool          `json:"enforced" bson:"enforced"`
+	Passed      bool          `json:"passed" bson:"passed"`
+	Waived      bool          `json:"waived,omitempty" bson:"waived,omitempty"`     // the rule failed, but a waiver exempts the artifact
+	WaiverId    string        `json:"waiverId,omitempty" bson:"waiverId,omitempty"` // the waiver applied
+	Message     string        `json:"message,omitempty" bson:"message,omitempty"`   // every failing check's problems
+	Checks      []CheckResult `json:"checks" bson:"checks"`
+}
+
+// The outcome of one limit, condition, expression or policy within a rule
+type CheckResult struct {
+	Key       string      `json:"key,omitempty" bson:"key,omitempty"`
+	LimitType string      `json:"limitType" bson:"limitType"`                   // min / condition / expression / rego
+	Expected  interface{} `json:"expected,omitempty" bson:"expected,omitempty"` // the limit value, condition or expression
+	Actual    interface{} `json:"actual,omitempty" bson:"actual,omitempty"`     // the value found at the key, unset when the key is missing
+	Passed    bool        `json:"passed" bson:"passed"`
+	Message   string      `json:"message,omitempty" bson:"message,omitempty"`
+}
+
 // Database & Collection for Validation & Mappings
 const validationDbName = "validationdb"
 const validationRuleColName = "validationrules"
 const validationRuleMappingColName = "validationmappings"
 
-// MongoDB client
-var client, _ = database.SetupMongoDbClient()
-
 // --------------------------------------------
 // Validation Rules
 // --------------------------------------------
@@ -56,6 +199,11 @@ func CreateRule(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	fmt.Println("Info: Creating new Validation Rule")
+
+	if policyReadOnly(w) {
+		return
+	}
+
 	var validationRule ValidationRule
 	err := json.NewDecoder(r.Body).Decode(&validationRule)
 
@@ -65,16 +213,19 @@ func CreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
-	result, err := collection.InsertOne(r.Context(), validationRule)
+	if fieldErrors := validationRule.validate(); len(fieldErrors) > 0 {
+		rejectRule(w, fieldErrors)
+		return
+	}
+
+	created, err := createVersionedRule(r.Context(), auth.GetActor(r), validationRule)
 	if err != nil {
 		http.Error(w, "Unable to insert the validationRule record into the database", 417)
 		log.Println(err)
 		return
 	}
 
-	validationRule.ID = result.InsertedID.(primitive.ObjectID)
-	json.NewEncoder(w).Encode(validationRule)
+	json.NewEncoder(w).Encode(created)
 }
 
 // Get all Validation Rule records
@@ -82,24 +233,14 @@ func GetRules(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	fmt.Println("Info: Getting all Validation Rules")
-	var validationRules []ValidationRule
-
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
-	cursor, err := collection.Find(r.Context(), bson.M{})
 
+	validationRules, err := GetRuleRepository().List(r.Context())
 	if err != nil {
 		http.Error(w, "Unable to check Validation Rule collection with unset ID", 500)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var validationRule ValidationRule
-		cursor.Decode(&validationRule)
-		validationRules = append(validationRules, validationRule)
-	}
-
 	json.NewEncoder(w).Encode(validationRules)
 }
 
@@ -108,11 +249,7 @@ func SearchRules(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse request body
-	var filter struct {
-		SearchKey   string `json:"searchKey"`
-		SearchValue string `json:"searchValue"`
-		SearchVerb  string `json:"searchVerb"`
-	}
+	var filter database.SearchFilter
 
 	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
 		http.Error(w, "Invalid request body", 422)
@@ -124,45 +261,14 @@ func SearchRules(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Searching Validation Rules by " + filter.SearchKey + " where the attribute is set to " + filter.SearchValue + " with verb set to: " + filter.SearchVerb)
 
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
-
-	// Build the filter
-	query := bson.M{}
-	if filter.SearchKey != "" && filter.SearchValue != "" {
-		if filter.SearchVerb == "contains" {
-			query = bson.M{filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}}
-		} else {
-			query = bson.M{filter.SearchKey: filter.SearchValue}
-		}
-	}
-
-	// Print the query to the log
-	_, err := json.Marshal(query)
-	if err != nil {
-		log.Println("Error marshaling query to JSON:", err)
-		http.Error(w, "Unable to marshal database query response to JSON", 500)
-		return
-	}
-
-	// Retrieve validationRules matching the query
-	var validationRules []ValidationRule
-	cursor, err := collection.Find(r.Context(), query)
+	// Retrieve validationRules matching the filter
+	validationRules, err := GetRuleRepository().Search(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "Unable to retrieve validationRules", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var validationRule ValidationRule
-		if err := cursor.Decode(&validationRule); err != nil {
-			log.Println("Error decoding validationRule:", err)
-			continue
-		}
-		validationRules = append(validationRules, validationRule)
-	}
-
 	json.NewEncoder(w).Encode(validationRules)
 }
 
@@ -184,11 +290,7 @@ func GetRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var validationRule ValidationRule
-
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
-
-	err = collection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&validationRule)
+	validationRule, err := GetRuleRepository().Get(r.Context(), id)
 	if err != nil {
 		// Handle the error / return a response
 		http.Error(w, "Unable to find validationRule with that ID", http.StatusBadRequest)
@@ -205,32 +307,58 @@ func UpdateRule(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Updating a specific validationRule record")
 
+	if policyReadOnly(w) {
+		return
+	}
+
 	params := mux.Vars(r)
 	id, _ := primitive.ObjectIDFromHex(params["id"])
 
 	var validationRule ValidationRule
-	_ = json.NewDecoder(r.Body).Decode(&validationRule)
+	if err := json.NewDecoder(r.Body).Decode(&validationRule); err != nil {
+		http.Error(w, "Unable to decode json into validationRule", 422)
+		log.Println(err)
+		return
+	}
+
+	stored, err := GetRuleRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find validationRule with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve validationRule", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
 
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
+	// A rule key that isn't given keeps the stored one, limits are checked against it
+	if validationRule.RuleKey == "" {
+		validationRule.RuleKey = stored.RuleKey
+	}
 
-	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
-	update := bson.M{
-		"$set": bson.M{
-			"name":        validationRule.Name,
-			"description": validationRule.Description,
-			"ruleFamily":  validationRule.RuleFamily,
-			"ruleLimits":  validationRule.RuleLimits,
-		},
+	if fieldErrors := validationRule.validate(); len(fieldErrors) > 0 {
+		rejectRule(w, fieldErrors)
+		return
 	}
 
-	_, err := collection.UpdateOne(r.Context(), bson.M{"_id": id}, update)
+	// Every update is a new version, mappings pinned to an earlier version keep evaluating it
+	updated, err := updateVersionedRule(r.Context(), auth.GetActor(r), *stored, validationRule)
+	if err == database.ErrConflict {
+		http.Error(w, "The validationRule was changed by another request, get the latest version & retry", http.StatusConflict)
+		return
+	}
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find validationRule with that ID", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		fmt.Println("Error receieved")
+		http.Error(w, "Unable to update the validationRule record", http.StatusInternalServerError)
 		log.Println(err)
+		return
 	}
 
-	validationRule.ID = id
-	json.NewEncoder(w).Encode(validationRule)
+	json.NewEncoder(w).Encode(updated)
 }
 
 // Delete an validationRule record
@@ -239,11 +367,14 @@ func DeleteRule(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Deleting a specific validationRule record")
 
+	if policyReadOnly(w) {
+		return
+	}
+
 	params := mux.Vars(r)
 	id, _ := primitive.ObjectIDFromHex(params["id"])
 
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
-	_, err := collection.DeleteOne(r.Context(), bson.M{"_id": id})
+	err := GetRuleRepository().Delete(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Unable to purge selected record out of the database", http.StatusBadRequest)
 		log.Println(err)
@@ -262,6 +393,11 @@ func CreateRuleMapping(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	fmt.Println("Info: Creating new Validation Mapping")
+
+	if policyReadOnly(w) {
+		return
+	}
+
 	var validationRuleMapping ValidationRuleMapping
 	err := json.NewDecoder(r.Body).Decode(&validationRuleMapping)
 
@@ -272,8 +408,7 @@ func CreateRuleMapping(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if there is a record for the chosen validationrule within the validationrules database
-	fmt.Println(validationDbName, validationRuleColName, validationRuleMapping.RuleId)
-	exists, err := existenceValidator(validationDbName, validationRuleColName, validationRuleMapping.RuleId)
+	exists, err := existenceValidator(r.Context(), validationRuleMapping.RuleId)
 	if err != nil {
 		http.Error(w, "Error checking validationRule collection", 500)
 		log.Println(err)
@@ -284,15 +419,17 @@ func CreateRuleMapping(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-	result, err := collection.InsertOne(r.Context(), validationRuleMapping)
+	if !checkPinnedVersion(w, r, validationRuleMapping) {
+		return
+	}
+
+	validationRuleMapping.ID, err = GetMappingRepository().Create(r.Context(), validationRuleMapping)
 	if err != nil {
 		http.Error(w, "Unable to insert the validationRuleMapping record into the database", 417)
 		log.Println(err)
 		return
 	}
 
-	validationRuleMapping.ID = result.InsertedID.(primitive.ObjectID)
 	json.NewEncoder(w).Encode(validationRuleMapping)
 }
 
@@ -301,24 +438,14 @@ func GetRuleMappings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	fmt.Println("Info: Getting all Validation Rules")
-	var validationRuleMappings []ValidationRuleMapping
-
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-	cursor, err := collection.Find(r.Context(), bson.M{})
 
+	validationRuleMappings, err := GetMappingRepository().List(r.Context())
 	if err != nil {
 		http.Error(w, "Unable to check Validation Rule collection with unset ID", 500)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var validationRuleMapping ValidationRuleMapping
-		cursor.Decode(&validationRuleMapping)
-		validationRuleMappings = append(validationRuleMappings, validationRuleMapping)
-	}
-
 	json.NewEncoder(w).Encode(validationRuleMappings)
 }
 
@@ -327,11 +454,7 @@ func SearchRuleMappings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse request body
-	var filter struct {
-		SearchKey   string `json:"searchKey"`
-		SearchValue string `json:"searchValue"`
-		SearchVerb  string `json:"searchVerb"`
-	}
+	var filter database.SearchFilter
 
 	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
 		http.Error(w, "Invalid request body", 422)
@@ -343,45 +466,14 @@ func SearchRuleMappings(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Searching Validation Rule Mappings by " + filter.SearchKey + " where the attribute is set to " + filter.SearchValue + " with verb set to: " + filter.SearchVerb)
 
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-
-	// Build the filter
-	query := bson.M{}
-	if filter.SearchKey != "" && filter.SearchValue != "" {
-		if filter.SearchVerb == "contains" {
-			query = bson.M{filter.SearchKey: primitive.Regex{Pattern: filter.SearchValue, Options: "i"}}
-		} else {
-			query = bson.M{filter.SearchKey: filter.SearchValue}
-		}
-	}
-
-	// Print the query to the log
-	_, err := json.Marshal(query)
-	if err != nil {
-		log.Println("Error marshaling query to JSON:", err)
-		http.Error(w, "Unable to marshal database query response to JSON", 500)
-		return
-	}
-
-	// Retrieve validationRuleMappings matching the query
-	var validationRuleMappings []ValidationRuleMapping
-	cursor, err := collection.Find(r.Context(), query)
+	// Retrieve validationRuleMappings matching the filter
+	validationRuleMappings, err := GetMappingRepository().Search(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "Unable to retrieve validationRuleMappings", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
 
-	defer cursor.Close(r.Context())
-	for cursor.Next(r.Context()) {
-		var validationRuleMapping ValidationRuleMapping
-		if err := cursor.Decode(&validationRuleMapping); err != nil {
-			log.Println("Error decoding validationRuleMapping:", err)
-			continue
-		}
-		validationRuleMappings = append(validationRuleMappings, validationRuleMapping)
-	}
-
 	json.NewEncoder(w).Encode(validationRuleMappings)
 }
 
@@ -403,11 +495,7 @@ func GetRuleMapping(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var validationRuleMapping ValidationRuleMapping
-
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-
-	err = collection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&validationRuleMapping)
+	validationRuleMapping, err := GetMappingRepository().Get(r.Context(), id)
 	if err != nil {
 		// Handle the error / return a response
 		http.Error(w, "Unable to find validationRuleMapping with that ID", http.StatusBadRequest)
@@ -424,34 +512,29 @@ func UpdateRuleMapping(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Updating a specific validationRuleMapping record")
 
+	if policyReadOnly(w) {
+		return
+	}
+
 	params := mux.Vars(r)
 	id, _ := primitive.ObjectIDFromHex(params["id"])
 
 	var validationRuleMapping ValidationRuleMapping
 	_ = json.NewDecoder(r.Body).Decode(&validationRuleMapping)
 
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-
-	// This logic needs improved to update only the fields passed within the PUT, rather than assuming they were all passed
-	update := bson.M{
-		"$set": bson.M{
-			"ruleId":       validationRuleMapping.ID,
-			"environments": validationRuleMapping.Environments,
-			"enforced":     validationRuleMapping.Enforced,
-		},
+	if !checkPinnedVersion(w, r, validationRuleMapping) {
+		return
 	}
 
-	type ValidationRuleMapping struct {
-		ID                 primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
-		RuleId             primitive.ObjectID     `json:"ruleId,omitempty" bson:"ruleId,omitempty"`             // 647f85e6e9fd4a733a4c6b8b
-		Environments       map[string]interface{} `json:"environments,omitempty" bson:"environments,omitempty"` // { development: true, preproduction: false, production: false }
-		Enforced           bool                   `json:"enforced,omitempty" bson:"enforced,omitempty"`         // false / true
+	err := GetMappingRepository().Update(r.Context(), id, validationRuleMapping)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find validationRuleMapping with that ID", http.StatusNotFound)
+		return
 	}
-
-	_, err := collection.UpdateOne(r.Context(), bson.M{"_id": id}, update)
 	if err != nil {
-		fmt.Println("Error receieved")
+		http.Error(w, "Unable to update the validationRuleMapping record", http.StatusInternalServerError)
 		log.Println(err)
+		return
 	}
 
 	validationRuleMapping.ID = id
@@ -464,11 +547,14 @@ func DeleteRuleMapping(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Info: Deleting a specific validationRuleMapping record")
 
+	if policyReadOnly(w) {
+		return
+	}
+
 	params := mux.Vars(r)
 	id, _ := primitive.ObjectIDFromHex(params["id"])
 
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-	_, err := collection.DeleteOne(r.Context(), bson.M{"_id": id})
+	err := GetMappingRepository().Delete(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Unable to purge selected record out of the database", http.StatusBadRequest)
 		log.Println(err)
@@ -501,142 +587,262 @@ func ValidateArtifact(w http.ResponseWriter, r *http.Request) {
 	//fmt.Println("Artifact Record:")
 	//fmt.Printf("%+v\n", artifact)
 
-	rules, err := getValidationRulesForEnvironment(req.Environment)
+	result, err := ValidateAndRecord(r, artifact, req.Environment, SourceValidation)
 	if err != nil {
 		http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
 		fmt.Println(err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Validate an inline artifact document against an Environment without storing anything
+func EvaluateInlineArtifact(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Info: Evaluating an inline artifact")
+
+	var req EvaluationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", 422)
+		log.Println(err)
+		return
+	}
+
+	if req.Artifact == nil || req.Environment == "" {
+		http.Error(w, "Both artifact and environment are required", 422)
+		return
+	}
+
+	result, err := EvaluateArtifact(r.Context(), req.Artifact, req.Environment)
+	if err != nil {
+		http.Error(w, "Failed to retrieve validation rules", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	// Inline artifacts don't need an ID
+	if req.Artifact.ID.IsZero() {
+		result.ArtifactId = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Evaluate an artifact against every rule mapped to the environment
+func EvaluateArtifact(ctx context.Context, artifact *artifacts.Artifact, environment string) (*ValidationResult, error) {
+
+	rules, err := getValidationRulesForEnvironment(ctx, artifact, environment)
+	if err != nil {
+		return nil, err
+	}
+
 	//fmt.Println("Rules below:")
 	//fmt.Printf("%+v\n", rules)
 
+	return evaluateAgainstRules(ctx, artifact, environment, rules), nil
+}
+
+// Build the validation result of an artifact against rules already loaded for the environment
+func evaluateAgainstRules(ctx context.Context, artifact *artifacts.Artifact, environment string, rules []mappedRule) *ValidationResult {
+
 	// Perform validation check
-	passesValidation, errorMap  := validateArtifactAgainstRules(artifact, rules)
-
-	// Return the result
-	result := struct {
-		ArtifactId        string            `json:"artifactId"`
-		PassesValidation  bool              `json:"passesValidation"`
-		Environment       string            `json:"environment"`
-		Violations        map[string]string `json:"violations,omitempty"`
-	}{
-		ArtifactId:        req.ArtifactID,
-		PassesValidation:  passesValidation,
-		Environment:       req.Environment,
-	}
-
-	if !passesValidation {
-		violations := make(map[string]string)
-		for ruleKey, err := range errorMap {
-			violations[ruleKey] = err.Error()
+	ruleResults := validateArtifactAgainstRules(ctx, artifact, environment, rules)
+
+	result := &ValidationResult{
+		ArtifactId:       artifact.ID.Hex(),
+		PassesValidation: true,
+		Environment:      environment,
+		Rules:            ruleResults,
+	}
+
+	// Only failures of enforced rules fail validation, the rest are returned as warnings or waived
+	for _, ruleResult := range ruleResults {
+		if ruleResult.Passed {
+			continue
+		}
+		if ruleResult.Waived {
+			if result.Waived == nil {
+				result.Waived = make(map[string]string)
+			}
+			result.Waived[ruleResult.RuleId] = ruleResult.Message
+		} else if ruleResult.Enforced {
+			if result.Violations == nil {
+				result.Violations = make(map[string]string)
+			}
+			result.Violations[ruleResult.RuleId] = ruleResult.Message
+			result.PassesValidation = false
+		} else {
+			if result.Warnings == nil {
+				result.Warnings = make(map[string]string)
+			}
+			result.Warnings[ruleResult.RuleId] = ruleResult.Message
 		}
-		result.Violations = violations
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	return result
 }
 
 func getArtifactByID(artifactID string) (*artifacts.Artifact, error) {
-	// Implementation to retrieve the artifact by ID from MongoDB
-	collection := client.Database(artifacts.ArtifactDbName).Collection(artifacts.ArtifactColName)
-
 	// Convert the string ID to an ObjectID if not already
 	id, err := primitive.ObjectIDFromHex(artifactID)
 	if err != nil {
 		return nil, err
 	}
 
-	var artifact artifacts.Artifact
+	return artifacts.GetRepository().Get(context.TODO(), id)
+}
 
-	err = collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&artifact)
+// Get the rules mapped to the environment that apply to the artifact
+func getValidationRulesForEnvironment(ctx context.Context, artifact *artifacts.Artifact, environment string) ([]mappedRule, error) {
+	rules, err := loadMappedRules(ctx, environment, make(ruleCache))
 	if err != nil {
 		return nil, err
 	}
-
-	return &artifact, nil
+	return applicableRules(*artifact, rules), nil
 }
 
-func getValidationRulesForEnvironment(environment string) ([]ValidationRule, error) {
-	// Implementation to retrieve the validation rules for the given environment from MongoDB
-	collection := client.Database(validationDbName).Collection(validationRuleMappingColName)
-
-	// Build the filter
-	query := bson.M{"environments." + environment: true}
-
-	var validationRules []ValidationRule
+// Load the rules mapped to an environment, rules already in the cache aren't read again
+func loadMappedRules(ctx context.Context, environment string, cache ruleCache) ([]mappedRule, error) {
+	validationRuleMappings, err := GetMappingRepository().ListByEnvironment(ctx, environment)
+	if err != nil {
+		return nil, err
+	}
 
-	cursor, err := collection.Find(context.TODO(), query)
+	waivers, err := getActiveWaivers(ctx, environment)
 	if err != nil {
 		return nil, err
 	}
 
-	defer cursor.Close(context.TODO())
+	var validationRules []mappedRule
 
-	for cursor.Next(context.TODO()) {
-		var validationRuleMapping ValidationRuleMapping
-		if err := cursor.Decode(&validationRuleMapping); err != nil {
-			return nil, err
+	for _, validationRuleMapping := range validationRuleMappings {
+		ref := ruleRef{ID: validationRuleMapping.RuleId, Version: validationRuleMapping.RuleVersion}
+		rule, ok := cache[ref]
+		if !ok {
+			rule, err = getRuleForMapping(ctx, validationRuleMapping)
+			if err != nil {
+				return nil, err
+			}
+			cache[ref] = rule
 		}
 
-		ruleID := validationRuleMapping.RuleId
-
-		rule, err := getRuleByID(ruleID)
-		if err != nil {
-			return nil, err
+		mapped := mappedRule{Rule: *rule, Mapping: validationRuleMapping}
+		for _, waiver := range waivers {
+			if waiver.RuleId == rule.ID {
+				mapped.Waivers = append(mapped.Waivers, waiver)
+			}
 		}
 
-		validationRules = append(validationRules, *rule)
+		validationRules = append(validationRules, mapped)
 	}
 
 	return validationRules, nil
 }
 
-func getRuleByID(ruleID primitive.ObjectID) (*ValidationRule, error) {
-	// Implementation to retrieve the validation rule by ID from MongoDB
-	collection := client.Database(validationDbName).Collection(validationRuleColName)
+// Evaluate every rule, reporting each rule's checks
+// Failures covered by one of the rule's waivers are marked as waived
+func validateArtifactAgainstRules(ctx context.Context, artifact *artifacts.Artifact, environment string, rules []mappedRule) []RuleResult {
+	return validateAgainstRules(ctx, artifact, environment, rules, nil)
+}
 
-	var validationRule ValidationRule
+// Evaluate every rule within a walk of the dependency graph, nil when the artifact is the one being validated
+func validateAgainstRules(ctx context.Context, artifact *artifacts.Artifact, environment string, rules []mappedRule, graph *dependencyGraph) []RuleResult {
 
-	err := collection.FindOne(context.TODO(), bson.M{"_id": ruleID}).Decode(&validationRule)
-	if err != nil {
-		return nil, err
-	}
-
-	return &validationRule, nil
-}
+	results := []RuleResult{}
 
-func validateArtifactAgainstRules(artifact *artifacts.Artifact, rules []ValidationRule) (bool, map[string]error) {
+	for _, mapped := range rules {
+		rule := mapped.Rule
+		fmt.Println("Info: evaluating rule", rule.ID.Hex(), rule.Name)
 
-	errors := make(map[string]error)
+		result := RuleResult{
+			RuleId:      rule.ID.Hex(),
+			Name:        rule.Name,
+			RuleFamily:  rule.RuleFamily,
+			RuleKey:     rule.RuleKey,
+			RuleVersion: rule.Version,
+			Enforced:    mapped.Mapping.IsEnforced(),
+			Passed:      true,
+			Checks:      rule.check(ctx, *artifact, environment, graph),
+		}
 
-	for _, rule := range rules {
-		for _, lim := range rule.RuleLimits {
-			fmt.Println(rule, ":", lim)
-			if err := lim.Evaluate(*artifact, rule.RuleKey); len(err.Problems) != 0 {
-				errors[rule.RuleKey] = err
+		var messages []string
+		for _, check := range result.Checks {
+			if !check.Passed {
+				result.Passed = false
+				messages = append(messages, check.Message)
+			}
+		}
+		result.Message = strings.Join(messages, "; ")
+
+		if !result.Passed {
+			for _, waiver := range mapped.Waivers {
+				if waiver.Covers(*artifact, result.RuleId) {
+					result.Waived = true
+					result.WaiverId = waiver.ID.Hex()
+					break
+				}
 			}
 		}
+
+		results = append(results, result)
 	}
 
-	return len(errors) == 0, errors
+	return results
 
 }
 
 // ------------------------------------------------------------------------------------------
 // Supporting Functions
 // ------------------------------------------------------------------------------------------
-// Function to check the existence of a record in a collection
-func existenceValidator(dbName string, colName string, id primitive.ObjectID) (bool, error) {
-	collection := client.Database(dbName).Collection(colName)
-	//fmt.Println("searching for")
-	//fmt.Println(id)
-	count, err := collection.CountDocuments(context.Background(), bson.M{"_id": id})
+// A mapping can only pin a version of its rule that exists, writes the error response when it doesn't
+func checkPinnedVersion(w http.ResponseWriter, r *http.Request, mapping ValidationRuleMapping) bool {
+	if mapping.RuleVersion == 0 {
+		return true
+	}
+	if mapping.RuleVersion < 0 {
+		http.Error(w, "Invalid Version Number", 422)
+		return false
+	}
+
+	_, err := GetRuleVersionRepository().Get(r.Context(), mapping.RuleId, mapping.RuleVersion)
+	if err == database.ErrNotFound {
+		http.Error(w, "Validation Rule version not found", 404)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Error checking validationRule versions", 500)
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// Reject a rule definition with the problem found in each field
+func rejectRule(w http.ResponseWriter, fieldErrors []FieldError) {
+	rejectFields(w, "Invalid rule", fieldErrors)
+}
+
+// Respond 422 with every field-level problem found
+func rejectFields(w http.ResponseWriter, message string, fieldErrors []FieldError) {
+	fmt.Println("Info: Rejecting,", message+",", len(fieldErrors), "problem(s) found")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(RuleValidationError{Error: message, Fields: fieldErrors})
+}
+
+// Function to check the existence of a validation rule
+func existenceValidator(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	_, err := GetRuleRepository().Get(ctx, id)
+	if err == database.ErrNotFound {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	return count > 0, nil
+	return true, nil
 }
 
 func lookup(artifact artifacts.Artifact, metadata map[string]any, keys []string) (any, bool) {
@@ -667,6 +873,22 @@ func lookup(artifact artifacts.Artifact, metadata map[string]any, keys []string)
 			"artifactFamily": artifact.ArtifactFamily,
 		}
 
+		// Server recorded timestamps, only present once the artifact has been stored
+		if artifact.CreatedAt != nil {
+			rootFields["createdAt"] = *artifact.CreatedAt
+		}
+		if artifact.UpdatedAt != nil {
+			rootFields["updatedAt"] = *artifact.UpdatedAt
+		}
+		// As a list of values, so list limits like count & any apply to it
+		if artifact.Dependencies != nil {
+			dependencies := make([]interface{}, len(artifact.Dependencies))
+			for i, dependency := range artifact.Dependencies {
+				dependencies[i] = dependency
+			}
+			rootFields["dependencies"] = dependencies
+		}
+
 		val, ok = rootFields[keys[0]]
 		//fmt.Println("Scanning root keys", val, ok, "for", keys[0])
 		if ok {
@@ -680,8 +902,8 @@ func lookup(artifact artifacts.Artifact, metadata map[string]any, keys []string)
 
 		//fmt.Println("Searching inside artifactmetadata", keys[0], metadata)
 
-		// base cases - this is a nil map, or no keys were given:
-		if metadata == nil || len(keys) == 0 {
+		// base cases - this is a nil map, or no child key was given:
+		if metadata == nil || len(keys) < 2 {
 			return nil, false
 		}
 
@@ -689,7 +911,7 @@ func lookup(artifact artifacts.Artifact, metadata map[string]any, keys []string)
 		val, ok := metadata[keys[1]] // As it needs to skip the first key in this case
 		//fmt.Println("Is the key found?", val, ok)
 		//fmt.Println(len(keys), keys)
-		if len(keys) == 1 || !ok {
+		if len(keys) == 2 || !ok {
 			return val, ok
 		}
 
@@ -743,6 +965,4 @@ func lookup(artifact artifacts.Artifact, metadata map[string]any, keys []string)
 		return lookup(artifact, child, keys[1:])
 	}
 
-	return nil, false
-
 }
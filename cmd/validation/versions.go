@@ -0,0 +1,161 @@
+package validation
+
+import (
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RuleVersion is an immutable snapshot of a rule, recorded on every create & update
+// Mappings can pin a version so a rule change doesn't reach every environment at once
+type RuleVersion struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RuleId    primitive.ObjectID `json:"ruleId" bson:"ruleId"`
+	Version   int                `json:"version" bson:"version"`     // 1, 2, 3... per rule
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"` // when the change was made
+	Actor     string             `json:"actor" bson:"actor"`         // user that made the change
+	Snapshot  ValidationRule     `json:"snapshot" bson:"snapshot"`   // the full rule after the change
+}
+
+// Collection for Validation Rule versions
+const validationRuleVersionColName = "validationruleversions"
+
+// Identifies a rule as it is loaded for a mapping, version 0 is the latest
+type ruleRef struct {
+	ID      primitive.ObjectID
+	Version int
+}
+
+// Rules already loaded while building the rule sets of one or more environments
+type ruleCache map[ruleRef]*ValidationRule
+
+// Get the rule a mapping applies, the pinned version when it has one & the latest otherwise
+func getRuleForMapping(ctx context.Context, mapping ValidationRuleMapping) (*ValidationRule, error) {
+	if mapping.RuleVersion == 0 {
+		return GetRuleRepository().Get(ctx, mapping.RuleId)
+	}
+	version, err := GetRuleVersionRepository().Get(ctx, mapping.RuleId, mapping.RuleVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &version.Snapshot, nil
+}
+
+// Store a new rule as its first version
+func createVersionedRule(ctx context.Context, actor string, rule ValidationRule) (*ValidationRule, error) {
+	var err error
+	rule.Version = 1
+	rule.ID, err = GetRuleRepository().Create(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRuleVersion(ctx, actor, rule); err != nil {
+		// A rule without its first version can't be pinned, so it isn't kept
+		if err := GetRuleRepository().Delete(ctx, rule.ID); err != nil {
+			log.Println("Error removing validation rule without a version:", err)
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Store a change to a rule as its next version, mappings pinned to an earlier version keep evaluating it
+// The rule is written exactly as given, replacing every field of the stored rule (UpdateRule fills in a missing rule key first)
+// database.ErrConflict when the rule was changed since stored was read, the change is not made
+func updateVersionedRule(ctx context.Context, actor string, stored ValidationRule, rule ValidationRule) (*ValidationRule, error) {
+	rule.ID = stored.ID
+	rule.Version = stored.Version + 1
+	if err := GetRuleRepository().Update(ctx, stored.ID, stored.Version, rule); err != nil {
+		return nil, err
+	}
+
+	if err := recordRuleVersion(ctx, actor, rule); err != nil {
+		// Put the stored rule back, unless another change has been made since
+		if err := GetRuleRepository().Update(ctx, stored.ID, rule.Version, stored); err != nil {
+			log.Println("Error restoring validation rule without a version:", err)
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Versions are what pinned mappings evaluate, so a change whose version can't be recorded fails
+func recordRuleVersion(ctx context.Context, actor string, rule ValidationRule) error {
+	version := RuleVersion{
+		RuleId:    rule.ID,
+		Version:   rule.Version,
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		Snapshot:  rule,
+	}
+	if _, err := GetRuleVersionRepository().Create(ctx, version); err != nil {
+		return fmt.Errorf("unable to record version %d of validation rule %s: %w", rule.Version, rule.ID.Hex(), err)
+	}
+	return nil
+}
+
+// Get all versions of a Validation Rule, oldest first
+func GetRuleVersions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting all versions of a specific validationRule record")
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid validationRule ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	versions, err := GetRuleVersionRepository().List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Unable to retrieve validationRule versions", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(versions)
+}
+
+// Get a specific version of a Validation Rule
+func GetRuleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting a specific version of a validationRule record")
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid validationRule ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	number, err := strconv.Atoi(params["version"])
+	if err != nil || number < 1 {
+		http.Error(w, "Invalid Version Number", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	version, err := GetRuleVersionRepository().Get(r.Context(), id, number)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find that version of the validationRule", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve validationRule version", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(version)
+}
@@ -0,0 +1,181 @@
+package validation
+
+import (
+	artifacts "artifactflow.com/m/v2/cmd/artifacts"
+	auth "artifactflow.com/m/v2/cmd/auth"
+	database "artifactflow.com/m/v2/cmd/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Waiver exempts an artifact, or every artifact in a family, from a rule in some environments until it expires
+type Waiver struct {
+	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RuleId         primitive.ObjectID `json:"ruleId" bson:"ruleId"`                                     // 647f85e6e9fd4a733a4c6b8b
+	ArtifactId     string             `json:"artifactId,omitempty" bson:"artifactId,omitempty"`         // either a single artifact
+	ArtifactFamily string             `json:"artifactFamily,omitempty" bson:"artifactFamily,omitempty"` // or every artifact in a family
+	Environments   []string           `json:"environments" bson:"environments"`                         // [ "preprod", "prod" ]
+	ExpiresAt      time.Time          `json:"expiresAt" bson:"expiresAt"`                               // the waiver stops applying at this time
+	Justification  string             `json:"justification" bson:"justification"`                       // CVE-2023-1234 is not reachable, fix scheduled
+	Approver       string             `json:"approver" bson:"approver"`                                 // who signed off the exemption
+	CreatedBy      string             `json:"createdBy" bson:"createdBy"`                               // set by the server
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`                               // set by the server
+}
+
+// Collection for Waivers
+const waiverColName = "waivers"
+
+// Check whether the waiver exempts the artifact from a rule
+func (waiver Waiver) Covers(artifact artifacts.Artifact, ruleID string) bool {
+	if waiver.RuleId.Hex() != ruleID {
+		return false
+	}
+	if waiver.ArtifactId != "" {
+		return waiver.ArtifactId == artifact.ID.Hex()
+	}
+	return waiver.ArtifactFamily != "" && waiver.ArtifactFamily == artifact.ArtifactFamily
+}
+
+// Get the waivers for an environment that haven't expired
+func getActiveWaivers(ctx context.Context, environment string) ([]Waiver, error) {
+	return GetWaiverRepository().ListActive(ctx, environment, time.Now().UTC())
+}
+
+// --------------------------------------------
+// Waivers
+// --------------------------------------------
+
+// Create a Waiver
+func CreateWaiver(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Creating new Waiver")
+	var waiver Waiver
+	if err := json.NewDecoder(r.Body).Decode(&waiver); err != nil {
+		http.Error(w, "Unable to decode json into waiver", 422)
+		log.Println(err)
+		return
+	}
+
+	if problem := checkWaiver(waiver); problem != "" {
+		http.Error(w, problem, 422)
+		return
+	}
+
+	// Check if there is a record for the chosen validationrule within the validationrules database
+	exists, err := existenceValidator(r.Context(), waiver.RuleId)
+	if err != nil {
+		http.Error(w, "Error checking validationRule collection", 500)
+		log.Println(err)
+		return
+	}
+	if !exists {
+		http.Error(w, "Validation Rule not found", 404)
+		return
+	}
+
+	waiver.CreatedBy = auth.GetActor(r)
+	waiver.CreatedAt = time.Now().UTC()
+
+	waiver.ID, err = GetWaiverRepository().Create(r.Context(), waiver)
+	if err != nil {
+		http.Error(w, "Unable to insert the waiver record into the database", 417)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(waiver)
+}
+
+// Get all Waivers, including expired ones
+func GetWaivers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting all Waivers")
+
+	waivers, err := GetWaiverRepository().List(r.Context())
+	if err != nil {
+		http.Error(w, "Unable to retrieve waivers", 500)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(waivers)
+}
+
+// Get a specific Waiver
+func GetWaiver(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Getting a specific waiver record")
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid waiver ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	waiver, err := GetWaiverRepository().Get(r.Context(), id)
+	if err == database.ErrNotFound {
+		http.Error(w, "Unable to find waiver with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to retrieve waiver", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(waiver)
+}
+
+// Delete (revoke) a Waiver
+func DeleteWaiver(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fmt.Println("Info: Deleting a specific waiver record")
+
+	params := mux.Vars(r)
+	id, _ := primitive.ObjectIDFromHex(params["id"])
+
+	err := GetWaiverRepository().Delete(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Unable to purge selected record out of the database", http.StatusBadRequest)
+		log.Println(err)
+	}
+
+	json.NewEncoder(w).Encode("Waiver record deleted successfully.")
+}
+
+// Returns why the waiver can't be created, or an empty string when it's valid
+func checkWaiver(waiver Waiver) string {
+	switch {
+	case (waiver.ArtifactId == "") == (waiver.ArtifactFamily == ""):
+		return "Exactly one of artifactId or artifactFamily is required"
+	case len(waiver.Environments) == 0:
+		return "At least one environment is required"
+	case waiver.ExpiresAt.IsZero():
+		return "An expiresAt timestamp is required"
+	case !waiver.ExpiresAt.After(time.Now()):
+		return "The waiver has already expired"
+	case waiver.Justification == "":
+		return "A justification is required"
+	case waiver.Approver == "":
+		return "An approver is required"
+	}
+
+	if waiver.ArtifactId != "" {
+		if _, err := primitive.ObjectIDFromHex(waiver.ArtifactId); err != nil {
+			return "Invalid Artifact ID"
+		}
+	}
+	return ""
+}